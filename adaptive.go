@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"time"
+)
+
+// Count the refreshes in a row getting the same price, the lock of the shard must be held
+func (c *TransparentCache[V]) trackVolatility(s *shard[V], itemCode string, old V, existed bool, price V) {
+	if !existed || !reflect.DeepEqual(old, price) {
+		s.stableByItem[itemCode] = 0
+		return
+	}
+	// stop counting once the TTL is at its max, so it can't overflow
+	if stable := s.stableByItem[itemCode]; c.adaptiveTTL(stable) < c.adaptiveMax {
+		s.stableByItem[itemCode] = stable + 1
+	}
+}
+
+// Get the TTL of an item whose price was the same for stable refreshes in a row
+func (c *TransparentCache[V]) adaptiveTTL(stable int) time.Duration {
+	ttl := c.adaptiveMin
+	for i := 0; i < stable && ttl < c.adaptiveMax; i++ {
+		ttl *= 2
+	}
+	if ttl > c.adaptiveMax {
+		return c.adaptiveMax
+	}
+	return ttl
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Check that a flipping price stays cached for the min TTL while a stable one grows up to the max TTL
+func TestWithAdaptiveTTL_FollowsVolatility(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"volatile": {price: 1, err: nil},
+			"stable":   {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithClock(clock), WithAdaptiveTTL(time.Second, 10*time.Second))
+	ttlOf := func(itemCode string) time.Duration {
+		expiresAt, ok := cache.ExpiresAt(itemCode)
+		if !ok {
+			t.Fatal("expected item to be cached", itemCode)
+		}
+		return expiresAt.Sub(clock.Now())
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, stableTTL := range expected {
+		mockService.setResult("volatile", mockResult{price: float64(i)})
+		getPriceWithNoErr(t, cache, "volatile")
+		getPriceWithNoErr(t, cache, "stable")
+		if ttl := ttlOf("volatile"); ttl != time.Second {
+			t.Error("wrong TTL for the volatile item", i, ttl)
+		}
+		if ttl := ttlOf("stable"); ttl != stableTTL {
+			t.Error("wrong TTL for the stable item", i, ttl)
+		}
+		clock.Advance(10 * time.Second)
+	}
+	assertInt(t, 12, mockService.getNumCalls(), "wrong number of service calls")
+}
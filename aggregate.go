@@ -0,0 +1,34 @@
+package main
+
+import "errors"
+
+// ErrNoItems is returned by AveragePriceFor when it is given no item codes, as there is no average of nothing
+var ErrNoItems = errors.New("no item codes")
+
+// SumPricesFor gets the prices for several items at once as GetPricesFor does, and adds them up
+// Repeated item codes are got once but added for every time they are given, as the items of a cart
+// When any item fails the sum is zero, with the same error GetPricesFor returns
+func (c *PriceCache) SumPricesFor(itemCodes ...string) (float64, error) {
+	prices, err := c.GetPricesFor(itemCodes...)
+	if err != nil {
+		return 0, err
+	}
+	sum := 0.0
+	for _, price := range prices {
+		sum += price
+	}
+	return sum, nil
+}
+
+// AveragePriceFor gets the prices for several items at once as GetPricesFor does, and averages them
+// Repeated item codes weigh for every time they are given, as in SumPricesFor
+func (c *PriceCache) AveragePriceFor(itemCodes ...string) (float64, error) {
+	if len(itemCodes) == 0 {
+		return 0, ErrNoItems
+	}
+	sum, err := c.SumPricesFor(itemCodes...)
+	if err != nil {
+		return 0, err
+	}
+	return sum / float64(len(itemCodes)), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Check that the prices are added up and averaged, repeated items counting every time, and got once
+func TestSumPricesFor_AddsUpAndAveragesThePrices(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7.5, err: nil},
+			"p3": {price: 2.5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	sum, err := cache.SumPricesFor("p1", "p2", "p3", "p1")
+	if err != nil {
+		t.Fatal("error summing prices", err)
+	}
+	assertFloat(t, 20, sum, "wrong sum")
+	assertInt(t, 3, mockService.getNumCalls(), "repeated item got more than once")
+	average, err := cache.AveragePriceFor("p1", "p2", "p3", "p1")
+	if err != nil {
+		t.Fatal("error averaging prices", err)
+	}
+	assertFloat(t, 5, average, "wrong average")
+	average, err = cache.AveragePriceFor("p2")
+	if err != nil {
+		t.Fatal("error averaging prices", err)
+	}
+	assertFloat(t, 7.5, average, "wrong average of a single item")
+	assertInt(t, 3, mockService.getNumCalls(), "cached prices got again")
+}
+
+// Check that nothing adds up to zero, and that there is no average of nothing
+func TestSumPricesFor_EmptyInput(t *testing.T) {
+	mockService := &mockPriceService{}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	sum, err := cache.SumPricesFor()
+	if err != nil {
+		t.Fatal("error summing no prices", err)
+	}
+	assertFloat(t, 0, sum, "wrong sum of no prices")
+	if average, err := cache.AveragePriceFor(); !errors.Is(err, ErrNoItems) || average != 0 {
+		t.Errorf("expected ErrNoItems and a zero average, got %v and %v", err, average)
+	}
+	assertInt(t, 0, mockService.getNumCalls(), "service called for no items")
+}
+
+// Check that a failing item fails the aggregates as it fails GetPricesFor
+func TestSumPricesFor_FailsAsGetPricesFor(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	_, expected := cache.GetPricesFor("p1", "p2")
+	var multiErr *MultiError
+	if sum, err := cache.SumPricesFor("p1", "p2"); !errors.As(err, &multiErr) || err.Error() != expected.Error() || sum != 0 {
+		t.Errorf("expected %v and a zero sum, got %v and %v", expected, err, sum)
+	}
+	if average, err := cache.AveragePriceFor("p1", "p2"); err == nil || err.Error() != expected.Error() || average != 0 {
+		t.Errorf("expected %v and a zero average, got %v and %v", expected, err, average)
+	}
+}
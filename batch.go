@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// Answer every group of positions from the cache and get all the missing prices with a single batch call
+// deliver is called once per position with its result
+// The batch call can't be cancelled, but it is not waited for once ctx is done
+func (c *TransparentCache[V]) fetchBatch(ctx context.Context, service *backend[V], itemCodes []string, groups itemGroups, deliver func(index int, price V, err error)) {
+	send := func(first int, price V, err error) {
+		groups.each(first, func(index int) { deliver(index, price, err) })
+	}
+	var zero V
+	var missing []string
+	var missingFirsts []int
+	for _, first := range groups.firsts {
+		itemCode := itemCodes[first]
+		if c.isClosed() {
+			send(first, zero, ErrClosed)
+			continue
+		}
+		if isEmptyItemCode(itemCode) {
+			send(first, zero, ErrEmptyItemCode)
+			continue
+		}
+		if price, _, err, ok := c.answerFromCache(itemCode); ok {
+			send(first, price, err)
+			continue
+		}
+		c.stats.misses.Add(1)
+		c.emit(itemCode, EventMiss)
+		if price, ok := c.loadFromStore(itemCode); ok {
+			send(first, price, nil)
+			continue
+		}
+		missing = append(missing, itemCode)
+		missingFirsts = append(missingFirsts, first)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	since := c.writes.Load()
+	prices, err := c.callBatch(ctx, service, missing)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		for _, first := range missingFirsts {
+			send(first, zero, ctxErr)
+		}
+		return
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		for i, itemCode := range missing {
+			if stale, ok := c.stalePrice(itemCode); ok {
+				c.emit(itemCode, EventStale)
+				send(missingFirsts[i], stale, nil)
+				continue
+			}
+			send(missingFirsts[i], zero, err)
+		}
+		return
+	}
+	if price, ok := c.cacheableValue(err); ok {
+		prices, err = make(map[string]V, len(missing)), nil
+		for _, itemCode := range missing {
+			prices[itemCode] = price
+		}
+	}
+	if err != nil {
+		err = c.wrapServiceError(err)
+		for i, itemCode := range missing {
+			c.storeFailure(itemCode, err)
+			if stale, ok := c.staleOnError(itemCode); ok {
+				send(missingFirsts[i], stale, nil)
+				continue
+			}
+			send(missingFirsts[i], zero, err)
+		}
+		return
+	}
+	found := make(map[string]V, len(missing))
+	for _, itemCode := range missing {
+		if price, ok := prices[itemCode]; ok {
+			found[itemCode] = c.scale(price)
+		}
+	}
+	raw := maps.Clone(found)
+	c.storePricesSince(found, since)
+	c.saveToStore(raw)
+	for i, itemCode := range missing {
+		price, ok := found[itemCode]
+		if !ok {
+			send(missingFirsts[i], zero, fmt.Errorf("%w : %w : no price for [%v]", ErrServiceFailure, ErrItemNotFound, itemCode))
+			continue
+		}
+		send(missingFirsts[i], price, nil)
+	}
+}
+
+// Call the batch service through the rate limiter and the circuit breaker, when there are
+func (c *TransparentCache[V]) callBatch(ctx context.Context, service *backend[V], itemCodes []string) (map[string]V, error) {
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if c.breaker != nil && !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	ctx, span := c.startSpan(ctx, "cache.service.GetPricesFor", "")
+	span.SetAttribute("item.count", len(itemCodes))
+	callCtx, cancel := c.withServiceTimeout(ctx)
+	prices, err := c.waitBatch(callCtx, service, itemCodes)
+	cancel()
+	err = timeoutError(ctx, callCtx, err)
+	c.reportError(ctx, itemCodes, err)
+	endServiceSpan(span, err)
+	if c.breaker != nil {
+		c.breaker.record(err)
+	}
+	return prices, err
+}
+
+// Call the batch service, reporting how long the call took to the event hook and waiting on ctx
+func (c *TransparentCache[V]) waitBatch(ctx context.Context, service *backend[V], itemCodes []string) (map[string]V, error) {
+	c.activeFetches.Add(1)
+	return detached(ctx, func() (map[string]V, error) {
+		defer c.activeFetches.Add(-1)
+		start := c.clock.Now()
+		prices, err := guarded(func() (map[string]V, error) { return service.getBatch(itemCodes) })
+		c.emitServiceCall("", start, err)
+		return prices, err
+	})
+}
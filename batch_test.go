@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockBatchPriceService is a PriceService with a batch endpoint, recording the items of each batch call
+type mockBatchPriceService struct {
+	mockPriceService
+	batchMu    sync.Mutex
+	batchCalls [][]string
+}
+
+func (m *mockBatchPriceService) GetPricesFor(itemCodes []string) (map[string]float64, error) {
+	m.batchMu.Lock()
+	m.batchCalls = append(m.batchCalls, append([]string(nil), itemCodes...))
+	m.batchMu.Unlock()
+	prices := map[string]float64{}
+	for _, itemCode := range itemCodes {
+		m.mu.Lock()
+		result, ok := m.mockResults[itemCode]
+		m.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		prices[itemCode] = result.price
+	}
+	return prices, nil
+}
+
+func (m *mockBatchPriceService) getBatchCalls() [][]string {
+	m.batchMu.Lock()
+	defer m.batchMu.Unlock()
+	return m.batchCalls
+}
+
+// Check that the items missing in the cache are got with a single batch call
+func TestGetPricesFor_UsesBatchService(t *testing.T) {
+	mockService := &mockBatchPriceService{mockPriceService: mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 9, err: nil},
+		},
+	}}
+	cache := NewTransparentCache(mockService)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloatsInOrder(t, []float64{7, 5, 9, 7}, getPricesWithNoErr(t, cache, "p2", "p1", "p3", "p2"), "wrong prices returned")
+	assertFloatsInOrder(t, []float64{9, 7}, getPricesWithNoErr(t, cache, "p3", "p2"), "wrong prices returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of single item service calls")
+	batchCalls := mockService.getBatchCalls()
+	assertInt(t, 1, len(batchCalls), "wrong number of batch service calls")
+	assertStrings(t, []string{"p2", "p3"}, batchCalls[0], "wrong items in the batch call")
+	// an item the batch endpoint has no price for is reported as an error
+	if _, err := cache.GetPricesFor("p1", "p4"); !errors.Is(err, ErrItemNotFound) || !strings.Contains(err.Error(), "no price for [p4]") {
+		t.Errorf("expected missing price error, got %v", err)
+	}
+}
+
+// Check that a service without a batch endpoint is called once per missing item
+func TestGetPricesFor_FallsBackToSingleCalls(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	assertFloatsInOrder(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "p1", "p2"), "wrong prices returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
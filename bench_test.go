@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Read heavy workload, many concurrent readers asking for prices that are already cached
+func BenchmarkGetPriceForHit(b *testing.B) {
+	mockService := &mockPriceService{mockResults: map[string]mockResult{}}
+	itemCodes := make([]string, 100)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("p%d", i)
+		mockService.mockResults[itemCodes[i]] = mockResult{price: float64(i)}
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Hour))
+	if _, err := cache.GetPricesFor(itemCodes...); err != nil {
+		b.Fatal("error warming the cache", err)
+	}
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := cache.GetPriceFor(itemCodes[i%len(itemCodes)]); err != nil {
+				b.Error("error getting price", err)
+			}
+			i++
+		}
+	})
+}
+
+// Write heavy workload, many concurrent writers storing prices, with a single lock and with shards
+func BenchmarkSetSharded(b *testing.B) {
+	itemCodes := make([]string, 1000)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("p%d", i)
+	}
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cache := NewTransparentCache(&mockPriceService{}, WithShards(shards))
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.Set(itemCodes[i%len(itemCodes)], float64(i))
+					i++
+				}
+			})
+		})
+	}
+}
+
+// Spread over the shards of structured codes sharing long prefixes, reported as the share of items
+// in the fullest shard over the share expected, where 1 means perfectly balanced
+func BenchmarkShardHasherBalance(b *testing.B) {
+	itemCodes := make([]string, 4096)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("STORE-%04d-SKU-%06d", i%8, i/8*64)
+	}
+	plainFNV := func(key string) uint64 {
+		hash := uint64(14695981039346656037)
+		for i := 0; i < len(key); i++ {
+			hash ^= uint64(key[i])
+			hash *= 1099511628211
+		}
+		return hash
+	}
+	hashers := map[string]func(key string) uint64{"default": DefaultShardHasher, "fnv": plainFNV}
+	for _, name := range []string{"default", "fnv"} {
+		b.Run(name, func(b *testing.B) {
+			cache := NewTransparentCache(&mockPriceService{}, WithShards(DefaultShards), WithShardHasher(hashers[name]))
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				cache.Set(itemCodes[n%len(itemCodes)], float64(n))
+			}
+			b.StopTimer()
+			counts := make([]int, len(cache.shards))
+			for _, itemCode := range itemCodes {
+				counts[hashers[name](itemCode)&uint64(len(counts)-1)]++
+			}
+			fullest := 0
+			for _, count := range counts {
+				fullest = max(fullest, count)
+			}
+			b.ReportMetric(float64(fullest*len(counts))/float64(len(itemCodes)), "max/even")
+		})
+	}
+}
+
+// Huge batch of cached prices, mostly measuring how the results are gathered
+func BenchmarkGetPricesFor100k(b *testing.B) {
+	mockService := &mockPriceService{mockResults: map[string]mockResult{}}
+	itemCodes := make([]string, 100000)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("p%d", i)
+		mockService.mockResults[itemCodes[i]] = mockResult{price: float64(i)}
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Hour))
+	if _, err := cache.GetPricesFor(itemCodes...); err != nil {
+		b.Fatal("error warming the cache", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.GetPricesFor(itemCodes...); err != nil {
+			b.Fatal("error getting prices", err)
+		}
+	}
+}
+
+// priceFunc is a PriceService answering right away with the price got by the function
+type priceFunc func(itemCode string) (float64, error)
+
+func (f priceFunc) GetPriceFor(itemCode string) (float64, error) {
+	return f(itemCode)
+}
+
+// A single cached price, got by GetPricesFor and by the general path with its workers
+func BenchmarkGetPricesForSingle(b *testing.B) {
+	mockService := &mockPriceService{mockResults: map[string]mockResult{"p1": {price: 5}}}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Hour))
+	getPrices := map[string]func() ([]float64, error){
+		"fast":    func() ([]float64, error) { return cache.GetPricesFor("p1") },
+		"general": func() ([]float64, error) { return cache.collect(context.Background(), []string{"p1"}) },
+	}
+	for _, path := range []string{"fast", "general"} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := getPrices[path](); err != nil {
+					b.Fatal("error getting prices", err)
+				}
+			}
+		})
+	}
+}
+
+// Many distinct cold items, every call fetches and stores a new price, with a single global lock for the fetches
+// in flight and with the stripes, on a single shard and on the default ones
+func BenchmarkGetPriceForColdStriped(b *testing.B) {
+	service := priceFunc(func(itemCode string) (float64, error) { return 1, nil })
+	for _, config := range []struct{ shards, stripes int }{{1, 1}, {1, DefaultLockStripes}, {DefaultShards, DefaultLockStripes}} {
+		b.Run(fmt.Sprintf("shards=%d,stripes=%d", config.shards, config.stripes), func(b *testing.B) {
+			cache := NewTransparentCache(service, WithShards(config.shards), WithLockStripes(config.stripes))
+			var next atomic.Int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := cache.GetPriceFor(fmt.Sprintf("p%d", next.Add(1))); err != nil {
+						b.Error("error getting price", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// Memory taken by a large set of cold items, as they are stored and once demoted
+func BenchmarkDemotedMemory(b *testing.B) {
+	const items = 100000
+	for _, demoted := range []bool{false, true} {
+		b.Run(fmt.Sprintf("demoted=%v", demoted), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				before := heapInUse()
+				cache := NewTransparentCache(&mockPriceService{}, WithShards(1))
+				for j := 0; j < items; j++ {
+					cache.Set(fmt.Sprintf("p%d", j), float64(j))
+				}
+				if demoted {
+					cache.demote(0)
+				}
+				b.ReportMetric(float64(heapInUse()-before)/items, "B/item")
+				runtime.KeepAlive(cache)
+			}
+		})
+	}
+}
+
+// Get the bytes of heap in use once the garbage is collected
+func heapInUse() int64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapInuse)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker around the service
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // the service is called as usual
+	CircuitOpen                         // the service is failing, calls fail fast until the cooldown is over
+	CircuitHalfOpen                     // a trial call is probing whether the service recovered
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker opens after threshold consecutive failures, and lets a single trial call through once cooldown is over
+type breaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+	state     CircuitState
+	failures  int // consecutive failures while closed
+	openedAt  time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration, clock Clock) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, clock: clock}
+}
+
+// Tell whether a call to the service can be made now, moving to half open when the cooldown is over
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		// the trial call is still running
+		return false
+	}
+	return true
+}
+
+// Record the outcome of a call allowed by allow, context errors tell nothing about the service
+func (b *breaker) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if b.state == CircuitHalfOpen {
+			// let another trial call through
+			b.state = CircuitOpen
+		}
+		return
+	}
+	if err == nil {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state = CircuitOpen
+		b.openedAt = b.clock.Now()
+		b.failures = 0
+	}
+}
+
+// Get the current state
+func (b *breaker) current() CircuitState {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// CircuitState returns the state of the circuit breaker, which is always closed when there is none
+func (c *TransparentCache[V]) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.current()
+}
+
+// Get the cached price for the item, however old it is, to answer while the circuit breaker is open
+func (c *TransparentCache[V]) stalePrice(itemCode string) (V, bool) {
+	price, _, _, ok := c.lookup(itemCode)
+	return price, ok
+}
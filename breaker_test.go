@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Check that the breaker opens after consecutive failures, probes once the cooldown is over, and closes on recovery
+func TestWithCircuitBreaker_GoesThroughEveryState(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 0, err: errors.New("service down")},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithClock(clock), WithCircuitBreaker(3, time.Minute))
+	for i := 0; i < 3; i++ {
+		if cache.CircuitState() != CircuitClosed {
+			t.Fatal("wrong state", cache.CircuitState())
+		}
+		if _, err := cache.GetPriceFor("p1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("expected the service error, got %v", err)
+		}
+	}
+	if cache.CircuitState() != CircuitOpen {
+		t.Fatal("wrong state", cache.CircuitState())
+	}
+	// fails fast without calling the service
+	if _, err := cache.GetPriceFor("p1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+
+	// the trial call fails, so the breaker opens again
+	clock.Advance(time.Minute)
+	if _, err := cache.GetPriceFor("p1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected the service error, got %v", err)
+	}
+	assertInt(t, 4, mockService.getNumCalls(), "wrong number of service calls")
+	if cache.CircuitState() != CircuitOpen {
+		t.Fatal("wrong state", cache.CircuitState())
+	}
+
+	// the trial call succeeds, so the breaker closes
+	clock.Advance(time.Minute)
+	mockService.setResult("p1", mockResult{price: 5})
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 5, mockService.getNumCalls(), "wrong number of service calls")
+	if cache.CircuitState() != CircuitClosed {
+		t.Fatal("wrong state", cache.CircuitState())
+	}
+}
+
+// Check that a single trial call is let through while half open
+func TestBreaker_SingleTrialWhileHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	b := newBreaker(1, time.Minute, clock)
+	b.record(errors.New("service down"))
+	if b.allow() {
+		t.Error("expected the breaker to be open")
+	}
+	clock.Advance(time.Minute)
+	if !b.allow() || b.current() != CircuitHalfOpen {
+		t.Fatal("expected a trial call", b.current())
+	}
+	if b.allow() {
+		t.Error("expected a single trial call while half open")
+	}
+	b.record(nil)
+	if !b.allow() || b.current() != CircuitClosed {
+		t.Error("expected the breaker to be closed", b.current())
+	}
+}
+
+// Check that while the breaker is open an expired price is served instead of failing
+func TestWithCircuitBreaker_ServesStalePriceWhileOpen(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: errors.New("service down")},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithClock(clock), WithMaxAge(time.Minute), WithCircuitBreaker(1, time.Hour))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	if _, err := cache.GetPriceFor("p2"); err == nil {
+		t.Error("expected the service error")
+	}
+	clock.Advance(2 * time.Minute)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
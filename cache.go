@@ -1,93 +1,1315 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// PriceService is a service that we can use to get prices for the items
-// Calls to this service are expensive (they take time)
-type PriceService interface {
-	GetPriceFor(itemCode string) (float64, error)
-}
-
 // TransparentCache is a cache that wraps the actual service
 // The cache will remember prices we ask for, so that we don't have to wait on every call
 // Cache should only return a price if it is not older than "maxAge", so that we don't get stale prices
-type TransparentCache struct {
-	sync.Mutex
-	actualPriceService PriceService
-	maxAge             time.Duration
-	prices             map[string]float64
-	expirationByItem   map[string]time.Time
+// Prices can be any type of value V, got by item code from the wrapped service
+type TransparentCache[V any] struct {
+	actualService   atomic.Pointer[backend[V]] // swapped by SetService, read once per call
+	maxAge          atomic.Int64               // time.Duration, it can be changed while the cache is in use
+	clock           Clock
+	staleGrace      time.Duration
+	maxStaleOnError time.Duration                       // 0 when service errors are returned even if there is an expired price
+	ttlClassifier   func(itemCode string) time.Duration // nil when every item is fresh for maxAge
+	keyNormalizer   func(itemCode string) string        // nil when item codes are cached as they are given
+	snapshotFile    string                              // empty unless the cache is saved to a snapshot file on close
+	snapshotOnClose bool
+	defaultCurrency string      // the currency of GetPriceFor, empty for the one of the service
+	currencies      currencySet // the other currencies prices were cached in, see GetPriceForCurrency
+	negativeTTL     time.Duration
+	refreshAhead    float64 // fraction of maxAge left under which prices are refreshed in the background
+	expiryJitter    float64
+	eventHook       func(ev CacheEvent)
+	retryAttempts   int
+	retryBaseDelay  time.Duration
+	isPermanent     func(err error) bool
+	breaker         *breaker // nil when there is no circuit breaker
+	limiter         *limiter // nil when the service calls are not rate limited
+	store           Store[V] // nil when there is no persistence
+	subs            subscribers[V]
+	normalize       func(price V) V // nil when prices are cached as they are got
+	cacheableError  func(err error) (V, bool)
+	healthProbe     string
+	bypass          atomic.Bool   // when set prices are got from the service without using the cache
+	tracer          Tracer        // nil when not tracing
+	adaptiveMin     time.Duration // zero when the TTL is not adaptive
+	adaptiveMax     time.Duration
+	isNotFound      func(err error) bool // nil when service errors are not told apart
+	serviceTimeout  time.Duration        // 0 when service calls are only bounded by the caller's context
+	itemTimeouts    itemTimeouts         // per item overrides of the service timeout
+	snapshotBatches bool                 // when set batches answer from the cache as it was when they started
+	fallback        *backend[V]          // nil when there is no service to fail over to
+	logger          Logger               // nil when the decisions of the cache are not logged
+	onError         func(itemCode string, err error)
+	shards          []*shard[V]
+	shardHasher     func(key string) uint64
+	stripes         []flightGroup[sourced[V]] // fetches in flight, see flightsFor
+	entries         atomic.Int64              // number of cached prices across every shard
+	writes          atomic.Uint64             // sequence of the writes, so a fetch doesn't clobber a price written while it ran
+	maxConcurrency  int
+	maxEntries      int
+	maxBytes        int64                                // 0 when the cache is not bounded by the estimated size
+	bytes           atomic.Int64                         // estimated size of the cached prices, when bounded by it
+	sizeEstimator   func(itemCode string, price V) int64 // nil for the default entry size
+	costOf          func(itemCode string, price V) int   // nil when eviction is by recency only
+	latency         latencyRecorder
+	activeFetches   atomic.Int64 // service calls in flight, see ActiveFetches
+	refreshes       *refreshQueue
+	transform       atomic.Pointer[func(itemCode string, raw V) V] // nil when prices are cached as they are got
+	debugInvariants bool                                           // when set the invariants are checked after every operation, see WithDebugInvariants
+	trackReads      bool                                           // when set the items read are noted, so WithDemotion keeps them
+	recency         *lru
+	stats           stats
+	lifetime        context.Context // cancelled when the cache is closed
+	stop            context.CancelFunc
+	background      sync.WaitGroup // background workers, waited on when closing
+	backgroundMu    sync.Mutex
+}
+
+// failure is a service error remembered by the negative cache
+type failure struct {
+	err      error
+	cachedAt time.Time
+}
+
+// PriceCache is the cache of float64 prices wrapping a PriceService
+type PriceCache struct {
+	*TransparentCache[float64]
+}
+
+// Create new Cache, by default prices are cached for DefaultMaxAge and the cache is not bounded
+func NewTransparentCache(actualPriceService PriceService, opts ...Option) *PriceCache {
+	c := &PriceCache{newCache[float64](nil, opts)}
+	c.SetService(actualPriceService)
+	return c
+}
+
+// New creates a cache of any type of value wrapping a generic Service, with the same options as NewTransparentCache
+func New[V any](actualService Service[V], opts ...Option) *TransparentCache[V] {
+	return newCache(newBackend(actualService), opts)
+}
+
+func newCache[V any](actualService *backend[V], opts []Option) *TransparentCache[V] {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &TransparentCache[V]{
+		clock:           o.clock,
+		staleGrace:      o.staleGrace,
+		maxStaleOnError: o.maxStaleOnError,
+		ttlClassifier:   o.ttlClassifier,
+		keyNormalizer:   o.keyNormalizer,
+		snapshotFile:    o.snapshotFile,
+		snapshotOnClose: o.snapshotOnClose && o.snapshotFile != "",
+		defaultCurrency: o.defaultCurrency,
+		negativeTTL:     o.negativeTTL,
+		refreshAhead:    o.refreshAhead,
+		expiryJitter:    o.expiryJitter,
+		eventHook:       o.eventHook,
+		retryAttempts:   o.retryAttempts,
+		retryBaseDelay:  o.retryBaseDelay,
+		isPermanent:     o.isPermanent,
+		maxConcurrency:  o.maxConcurrency,
+		healthProbe:     o.healthProbe,
+		tracer:          o.tracer,
+		adaptiveMin:     o.adaptiveMin,
+		adaptiveMax:     o.adaptiveMax,
+		isNotFound:      o.isNotFound,
+		serviceTimeout:  o.serviceTimeout,
+		snapshotBatches: o.snapshotBatches,
+		logger:          o.logger,
+		onError:         o.onError,
+		debugInvariants: o.debugInvariants,
+		trackReads:      o.demoteAfter > 0,
+		shards:          newShards[V](o.shards),
+		shardHasher:     o.shardHasher,
+		stripes:         newStripes[V](o.lockStripes),
+		refreshes:       newRefreshQueue(o.refreshWorkers, o.refreshQueueSize),
+	}
+	c.maxAge.Store(int64(o.maxAge))
+	if actualService != nil {
+		c.actualService.Store(actualService)
+	}
+	c.lifetime, c.stop = context.WithCancel(context.Background())
+	if sizeEstimator, ok := o.sizeEstimator.(func(string, V) int64); ok {
+		c.sizeEstimator = sizeEstimator
+	}
+	if costOf, ok := o.costFunc.(func(string, V) int); ok {
+		c.costOf = costOf
+	}
+	if transform, ok := o.transform.(func(string, V) V); ok {
+		c.transform.Store(&transform)
+	}
+	if o.maxEntries > 0 || o.maxBytes > 0 {
+		c.maxEntries = o.maxEntries
+		c.maxBytes = o.maxBytes
+		c.recency = newLRU()
+	}
+	if o.breakerAfter > 0 {
+		c.breaker = newBreaker(o.breakerAfter, o.breakerFor, c.clock)
+	}
+	if o.priceScale != nil {
+		// only float64 prices can be rounded, so it is left nil for other values
+		c.normalize, _ = any(roundFunc(*o.priceScale)).(func(V) V)
+	}
+	if cacheableError, ok := o.cacheableError.(func(error) (V, bool)); ok {
+		c.cacheableError = cacheableError
+	}
+	if store, ok := o.store.(Store[V]); ok {
+		c.store = store
+	}
+	c.fallback = fallbackBackend[V](o.fallback, o.defaultCurrency)
+	if o.rateLimit > 0 {
+		c.limiter = newLimiter(o.rateLimit, o.rateBurst)
+	}
+	// seeded once the cache is configured, so the prices get the same treatment as the ones got later
+	if prices, ok := o.initialPrices.(map[string]V); ok {
+		c.seed(prices, o.initialTimes)
+	}
+	if o.snapshotFile != "" {
+		if err := c.restoreFile(o.snapshotFile); err != nil && c.logger != nil {
+			// the cache can't fail to be created, so it starts cold instead
+			c.logger.Warnf("starting without the snapshot of %v : %v", o.snapshotFile, err)
+		}
+	}
+	if o.janitorEvery > 0 {
+		c.goBackground(func() { c.runJanitor(o.janitorEvery) })
+	}
+	if o.demoteAfter > 0 {
+		c.goBackground(func() { c.runDemotion(o.demoteAfter) })
+	}
+	return c
 }
 
-//Create new Cache
-func NewTransparentCache(actualPriceService PriceService, maxAge time.Duration) *TransparentCache {
-	return &TransparentCache{
-		actualPriceService: actualPriceService,
-		maxAge:             maxAge,
-		prices:             map[string]float64{},
-		expirationByItem:   map[string]time.Time{},
+// Store the initial prices, stamped with their time when there is one
+func (c *TransparentCache[V]) seed(prices map[string]V, cachedAt map[string]time.Time) {
+	now := c.clock.Now()
+	for itemCode, price := range prices {
+		at, ok := cachedAt[itemCode]
+		if !ok {
+			at = now
+		}
+		itemCode = c.key(itemCode)
+		if isEmptyItemCode(itemCode) {
+			continue
+		}
+		s := c.shardFor(itemCode)
+		s.Lock()
+		c.storeDerivedAt(s, itemCode, c.scale(price), at)
+		s.Unlock()
 	}
+	c.evictOverflow()
+}
+
+// Create new Cache running at most maxConcurrency service calls at once in GetPricesFor
+//
+// Deprecated: use NewTransparentCache with WithMaxAge and WithMaxConcurrency
+func NewTransparentCacheWithConcurrency(actualPriceService PriceService, maxAge time.Duration, maxConcurrency int) *PriceCache {
+	return NewTransparentCache(actualPriceService, WithMaxAge(maxAge), WithMaxConcurrency(maxConcurrency))
+}
+
+// Create new Cache holding at most maxEntries prices, evicting the least recently used ones
+//
+// Deprecated: use NewTransparentCache with WithMaxAge and WithMaxEntries
+func NewTransparentCacheWithMaxEntries(actualPriceService PriceService, maxAge time.Duration, maxEntries int) *PriceCache {
+	return NewTransparentCache(actualPriceService, WithMaxAge(maxAge), WithMaxEntries(maxEntries))
 }
 
 // GetPriceFor gets the price for the item, either from the cache or the actual service if it was not cached or too old
-func (c *TransparentCache) GetPriceFor(itemCode string) (float64, error) {
-	price, ok := c.prices[itemCode]
-	if ok {
-		if c.expirationByItem[itemCode].Add(c.maxAge).After(time.Now()) {
+func (c *TransparentCache[V]) GetPriceFor(itemCode string) (V, error) {
+	return c.GetPriceForContext(context.Background(), itemCode)
+}
+
+// GetPriceForFresh gets the price for the item as GetPriceFor does, but the cached price is only returned
+// when it is not older than maxStale, whatever the max age of the cache is
+func (c *TransparentCache[V]) GetPriceForFresh(itemCode string, maxStale time.Duration) (V, error) {
+	itemCode = c.key(itemCode)
+	var zero V
+	if c.isClosed() {
+		return zero, ErrClosed
+	}
+	if isEmptyItemCode(itemCode) {
+		return zero, ErrEmptyItemCode
+	}
+	if c.bypass.Load() {
+		price, _, err := c.fetchBypassing(context.Background(), itemCode)
+		return price, err
+	}
+	if price, ok := c.getPriceWithin(itemCode, maxStale); ok {
+		c.stats.hits.Add(1)
+		c.emit(itemCode, EventHit)
+		return price, nil
+	}
+	c.stats.misses.Add(1)
+	c.emit(itemCode, EventMiss)
+	result, err := c.flightsFor(itemCode).do(context.Background(), itemCode, func(ctx context.Context) (sourced[V], error) {
+		if price, ok := c.getPriceWithin(itemCode, maxStale); ok {
+			return sourced[V]{price: price, source: SourceHit}, nil
+		}
+		price, source, err := c.refreshPrice(ctx, itemCode)
+		return sourced[V]{price: price, source: source}, err
+	})
+	return result.price, err
+}
+
+// Get the cached price if it is not older than maxStale
+func (c *TransparentCache[V]) getPriceWithin(itemCode string, maxStale time.Duration) (V, bool) {
+	price, cachedAt, _, ok := c.lookup(itemCode)
+	if !ok || c.clock.Now().Sub(cachedAt) >= maxStale {
+		var zero V
+		return zero, false
+	}
+	return price, true
+}
+
+// TryGetPriceFor returns the fresh cached price for the item without ever waiting on the service
+// When there is none it returns right away with ok false, and the price is got in the background
+// so a later try finds it, while bypassing the cache there is never a price nor a fetch in the background
+func (c *TransparentCache[V]) TryGetPriceFor(itemCode string) (price V, ok bool) {
+	itemCode = c.key(itemCode)
+	if c.isClosed() || isEmptyItemCode(itemCode) || c.bypass.Load() {
+		return price, false
+	}
+	if price, ok := c.getCachedPrice(itemCode); ok {
+		c.stats.hits.Add(1)
+		c.emit(itemCode, EventHit)
+		return price, true
+	}
+	c.stats.misses.Add(1)
+	c.emit(itemCode, EventMiss)
+	c.refreshAsync(itemCode)
+	return price, false
+}
+
+// GetPriceForEventual gets the cached price for the item right away, however old it is, refreshing it
+// in the background when it expired, and only waits on the service when the item is not cached at all
+// Unlike WithStaleWhileRevalidate there is no bound on how old the returned price can be
+func (c *TransparentCache[V]) GetPriceForEventual(itemCode string) (V, error) {
+	itemCode = c.key(itemCode)
+	if !c.isClosed() && !isEmptyItemCode(itemCode) && !c.bypass.Load() {
+		if price, cachedAt, maxAge, ok := c.lookup(itemCode); ok {
+			c.stats.hits.Add(1)
+			if maxAge <= 0 || c.clock.Now().Sub(cachedAt) < maxAge {
+				c.emit(itemCode, EventHit)
+				return price, nil
+			}
+			c.emit(itemCode, EventStale)
+			c.refreshAsync(itemCode)
 			return price, nil
 		}
 	}
-	price, err := c.actualPriceService.GetPriceFor(itemCode)
+	// the item code is already normalized
+	price, _, err := c.getPrice(context.Background(), itemCode)
+	return price, err
+}
+
+// GetPriceForOrDefault gets the price for the item as GetPriceFor does, but never fails
+// When the price can't be got the cached one is returned however old it is, or fallback if there is none
+func (c *TransparentCache[V]) GetPriceForOrDefault(itemCode string, fallback V) V {
+	itemCode = c.key(itemCode)
+	price, _, err := c.getPrice(context.Background(), itemCode)
+	if err == nil {
+		return price
+	}
+	if stale, ok := c.stalePrice(itemCode); ok {
+		return stale
+	}
+	return fallback
+}
+
+// SetBypass turns the pass-through mode on or off, while it is on prices are always got from the service
+// without reading nor writing the cache, which is left as it was for when it is turned off
+func (c *TransparentCache[V]) SetBypass(bypass bool) {
+	c.bypass.Store(bypass)
+}
+
+// Get the price from the service without using the cache
+func (c *TransparentCache[V]) fetchBypassing(ctx context.Context, itemCode string) (V, Source, error) {
+	price, source, err := c.fetchWithFallback(ctx, itemCode)
 	if err != nil {
-		return 0, fmt.Errorf("getting price from service : %v", err.Error())
+		var zero V
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return zero, source, ctxErr
+		}
+		return zero, source, c.wrapServiceError(err)
+	}
+	return c.scale(price), source, nil
+}
+
+// PriceResult is the outcome of GetPriceForAsync
+type PriceResult[V any] struct {
+	Price V
+	Err   error
+}
+
+// GetPriceForAsync gets the price for the item as GetPriceFor does, without waiting for it
+// The returned channel receives exactly one result once it is ready, and is closed then
+func (c *TransparentCache[V]) GetPriceForAsync(itemCode string) <-chan PriceResult[V] {
+	// buffered so the result can be sent even if nobody receives it
+	result := make(chan PriceResult[V], 1)
+	go func() {
+		defer close(result)
+		price, err := c.GetPriceFor(itemCode)
+		result <- PriceResult[V]{Price: price, Err: err}
+	}()
+	return result
+}
+
+// GetPriceForContext is like GetPriceFor but gives up when ctx is done, returning ctx.Err()
+// A price received after ctx is done is not stored in the cache
+func (c *TransparentCache[V]) GetPriceForContext(ctx context.Context, itemCode string) (V, error) {
+	itemCode = c.key(itemCode)
+	price, _, err := c.getPrice(ctx, itemCode)
+	return price, err
+}
+
+// Get the price as GetPriceForContext does, telling where it came from
+func (c *TransparentCache[V]) getPrice(ctx context.Context, itemCode string) (V, Source, error) {
+	defer c.checkInvariants()
+	if c.isClosed() {
+		var zero V
+		return zero, SourceMiss, ErrClosed
+	}
+	if isEmptyItemCode(itemCode) {
+		var zero V
+		return zero, SourceMiss, ErrEmptyItemCode
+	}
+	ctx, span := c.startSpan(ctx, "cache.GetPriceFor", itemCode)
+	defer span.End()
+	if c.bypass.Load() {
+		span.SetAttribute("cache.hit", false)
+		return c.fetchBypassing(ctx, itemCode)
+	}
+	if price, source, err, ok := c.answerFromCache(itemCode); ok {
+		span.SetAttribute("cache.hit", true)
+		return price, source, err
+	}
+	span.SetAttribute("cache.hit", false)
+	c.stats.misses.Add(1)
+	c.emit(itemCode, EventMiss)
+	if err := ctx.Err(); err != nil {
+		var zero V
+		return zero, SourceMiss, err
+	}
+	result, err := c.flightsFor(itemCode).do(ctx, itemCode, func(ctx context.Context) (sourced[V], error) {
+		return c.loadPrice(ctx, itemCode)
+	})
+	return result.price, result.source, err
+}
+
+// Answer with the cached price or error for the item when there is a usable one, counting it as a hit
+// Prices close to expiring or within the stale grace are refreshed in the background
+func (c *TransparentCache[V]) answerFromCache(itemCode string) (V, Source, error, bool) {
+	if price, cachedAt, maxAge, ok := c.lookup(itemCode); ok {
+		age := c.clock.Now().Sub(cachedAt)
+		if maxAge <= 0 || age < maxAge {
+			c.stats.hits.Add(1)
+			c.emit(itemCode, EventHit)
+			if maxAge > 0 && float64(maxAge-age) < float64(maxAge)*c.refreshAhead {
+				c.refreshAsync(itemCode)
+			}
+			return price, SourceHit, nil, true
+		}
+		if age < maxAge+c.staleGrace {
+			c.stats.hits.Add(1)
+			c.emit(itemCode, EventStale)
+			c.refreshAsync(itemCode)
+			return price, SourceStale, nil, true
+		}
+	}
+	var zero V
+	if err, ok := c.getCachedFailure(itemCode); ok {
+		c.stats.hits.Add(1)
+		if stale, ok := c.staleOnError(itemCode); ok {
+			return stale, SourceStale, nil, true
+		}
+		c.emit(itemCode, EventHit)
+		return zero, SourceHit, err, true
+	}
+	return zero, SourceMiss, nil, false
+}
+
+// Load the price from the service and store it, this runs once per item code in flight
+func (c *TransparentCache[V]) loadPrice(ctx context.Context, itemCode string) (sourced[V], error) {
+	// another flight may have stored the price right after our cache lookup
+	if price, ok := c.getCachedPrice(itemCode); ok {
+		return sourced[V]{price: price, source: SourceHit}, nil
+	}
+	if price, ok := c.loadFromStore(itemCode); ok {
+		return sourced[V]{price: price, source: SourceStore}, nil
+	}
+	price, source, err := c.refreshPrice(ctx, itemCode)
+	if errors.Is(err, ErrCircuitOpen) {
+		if stale, ok := c.stalePrice(itemCode); ok {
+			c.emit(itemCode, EventStale)
+			return sourced[V]{price: stale, source: SourceStale}, nil
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		if stale, ok := c.staleOnError(itemCode); ok {
+			return sourced[V]{price: stale, source: SourceStale}, nil
+		}
+	}
+	return sourced[V]{price: price, source: source}, err
+}
+
+// Get the cached price to return instead of a service error, when it is within WithMaxStaleOnError
+func (c *TransparentCache[V]) staleOnError(itemCode string) (V, bool) {
+	if c.maxStaleOnError <= 0 {
+		var zero V
+		return zero, false
+	}
+	price, ok := c.getPriceWithin(itemCode, c.maxStaleOnError)
+	if ok {
+		c.emit(itemCode, EventStale)
+	}
+	return price, ok
+}
+
+// Get the price from the service and store it, even if the cached one is still fresh
+// The source tells whether the price came from the actual service or from the fallback one
+func (c *TransparentCache[V]) refreshPrice(ctx context.Context, itemCode string) (V, Source, error) {
+	price, version, ok := c.revalidate(ctx, itemCode)
+	if ok {
+		return price, SourceMiss, nil
+	}
+	return c.fetchAndStore(ctx, itemCode, version)
+}
+
+// Get the price from the service and store it along with the version, if any, without revalidating it first
+func (c *TransparentCache[V]) fetchAndStore(ctx context.Context, itemCode string, version string) (V, Source, error) {
+	// the lock is not held while calling the service, so slow calls don't block other items
+	since := c.writes.Load()
+	price, source, err := c.fetchWithFallback(ctx, itemCode)
+	var zero V
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return zero, source, ctxErr
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return zero, source, err
+	}
+	if price, ok := c.cacheableValue(err); ok {
+		return c.storeFetched(itemCode, price, "", since), source, nil
+	}
+	if err != nil {
+		err = c.wrapServiceError(err)
+		c.storeFailure(itemCode, err)
+		return zero, source, err
+	}
+	return c.storeFetched(itemCode, price, version, since), source, nil
+}
+
+// Store a price got from the service along with its version, if any, returning it as it was cached
+// A price written after the fetch began, at the write sequence since, is kept and returned instead
+func (c *TransparentCache[V]) storeFetched(itemCode string, price V, version string, since uint64) V {
+	raw := map[string]V{itemCode: c.scale(price)}
+	fetched := map[string]V{itemCode: price}
+	if c.storePricesSince(fetched, since) == 0 {
+		return fetched[itemCode]
+	}
+	if version != "" {
+		s := c.shardFor(itemCode)
+		s.Lock()
+		if _, ok := s.prices[itemCode]; ok {
+			s.versionByItem[itemCode] = version
+		}
+		s.Unlock()
+	}
+	c.saveToStore(raw)
+	return fetched[itemCode]
+}
+
+// Get the value to cache in place of the service error, when it is a cacheable error
+func (c *TransparentCache[V]) cacheableValue(err error) (V, bool) {
+	if err == nil || c.cacheableError == nil {
+		var zero V
+		return zero, false
+	}
+	return c.cacheableError(err)
+}
+
+// Set stores the price for the item as if it was just got from the service
+// It can be used to warm the cache or override a cached price
+// Empty item codes are never cached, setting them is ignored as getting them fails with ErrEmptyItemCode
+func (c *TransparentCache[V]) Set(itemCode string, price V) {
+	itemCode = c.key(itemCode)
+	if isEmptyItemCode(itemCode) {
+		return
+	}
+	c.storePrices(map[string]V{itemCode: price})
+}
+
+// SetWithTTL stores the price for the item as Set does, but it is fresh for ttl instead of maxAge
+// The TTL sticks to the item, it is honored when the price is refreshed too, until the item is removed from the cache
+func (c *TransparentCache[V]) SetWithTTL(itemCode string, price V, ttl time.Duration) {
+	itemCode = c.key(itemCode)
+	if isEmptyItemCode(itemCode) {
+		return
+	}
+	raw := c.scale(price)
+	s := c.shardFor(itemCode)
+	s.Lock()
+	price, old, existed := c.storeDerived(s, itemCode, raw)
+	s.ttlByItem[itemCode] = ttl
+	s.Unlock()
+	c.emitEvictions(c.evictOverflow())
+	c.publish(c.updates(nil, itemCode, old, existed, price))
+	// the TTL is not saved, the caches loading the price judge it by their own
+	c.saveToStore(map[string]V{itemCode: raw})
+}
+
+// SetMany stores several prices at once, as Set does, skipping the empty item codes
+func (c *TransparentCache[V]) SetMany(prices map[string]V) {
+	// the prices are replaced as they are cached, the caller's map is left alone
+	normalized := make(map[string]V, len(prices))
+	for itemCode, price := range prices {
+		if itemCode = c.key(itemCode); !isEmptyItemCode(itemCode) {
+			normalized[itemCode] = price
+		}
+	}
+	c.storePrices(normalized)
+}
+
+// Store the prices, each under the lock of its shard, and then evict what doesn't fit anymore
+// Subscribers are told about the prices which changed, and the prices are saved to the store as the fetched ones
+func (c *TransparentCache[V]) storePrices(prices map[string]V) {
+	raw := maps.Clone(prices)
+	c.storePricesSince(prices, math.MaxUint64)
+	c.saveToStore(raw)
+}
+
+// Store the prices as storePrices does, but only for the items not written after the write sequence since
+// The prices are replaced in prices as they were cached, derived by the transform if there is one,
+// or by the newer cached ones for the items written meanwhile
+// It returns how many prices were stored
+func (c *TransparentCache[V]) storePricesSince(prices map[string]V, since uint64) int {
+	var updates []PriceUpdate[V]
+	stored := 0
+	for itemCode, price := range prices {
+		price = c.scale(price)
+		s := c.shardFor(itemCode)
+		s.Lock()
+		if s.writeByItem[itemCode] > since {
+			prices[itemCode] = s.prices[itemCode]
+			s.Unlock()
+			continue
+		}
+		price, old, existed := c.storeDerived(s, itemCode, price)
+		s.Unlock()
+		prices[itemCode] = price
+		stored++
+		updates = c.updates(updates, itemCode, old, existed, price)
+	}
+	c.emitEvictions(c.evictOverflow())
+	c.publish(updates)
+	return stored
+}
+
+// Store the price stamped with the current time, the lock of the shard must be held
+// It returns the price it replaced, if there was one
+func (c *TransparentCache[V]) storeEntry(s *shard[V], itemCode string, price V) (V, bool) {
+	return c.storeEntryAt(s, itemCode, price, c.clock.Now())
+}
+
+// Store the price stamped as got at cachedAt, the lock of the shard must be held
+// It returns the price it replaced, if there was one
+func (c *TransparentCache[V]) storeEntryAt(s *shard[V], itemCode string, price V, cachedAt time.Time) (V, bool) {
+	old, existed := s.prices[itemCode]
+	if !existed {
+		c.entries.Add(1)
+	}
+	price = c.scale(price)
+	if c.maxBytes > 0 {
+		if existed {
+			c.bytes.Add(-c.sizeOf(itemCode, old))
+		}
+		c.bytes.Add(c.sizeOf(itemCode, price))
+	}
+	if c.adaptiveMin > 0 {
+		c.trackVolatility(s, itemCode, old, existed, price)
+	}
+	if c.costOf != nil {
+		s.costByItem[itemCode] = c.costOf(itemCode, price)
+	}
+	s.prices[itemCode] = price
+	s.stamp(itemCode, cachedAt)
+	s.writeByItem[itemCode] = c.writes.Add(1)
+	if c.expiryJitter > 0 {
+		s.jitterByItem[itemCode] = 1 + (rand.Float64()*2-1)*c.expiryJitter
+	}
+	delete(s.failures, itemCode)
+	delete(s.rawByItem, itemCode)
+	c.touch(s, itemCode)
+	return old, existed
+}
+
+// Evict the least recently used prices while there are more than maxEntries, or more estimated bytes than maxBytes,
+// no lock must be held
+// It returns the evicted item codes
+func (c *TransparentCache[V]) evictOverflow() []string {
+	defer c.checkInvariants()
+	if c.recency == nil {
+		return nil
+	}
+	var evicted []string
+	for c.overflowing() {
+		itemCode, ok := c.evictionCandidate()
+		if !ok {
+			break
+		}
+		s := c.shardFor(itemCode)
+		s.Lock()
+		if _, pinned := s.pinned[itemCode]; pinned {
+			c.recency.remove(itemCode)
+		} else if _, ok := s.prices[itemCode]; ok {
+			c.removeEntry(s, itemCode)
+			c.stats.evictions.Add(1)
+			evicted = append(evicted, itemCode)
+		} else {
+			c.recency.remove(itemCode)
+		}
+		s.Unlock()
+	}
+	return evicted
+}
+
+// Remove the item from every map, the lock of the shard must be held
+func (c *TransparentCache[V]) removeEntry(s *shard[V], itemCode string) {
+	if price, ok := s.prices[itemCode]; ok {
+		c.entries.Add(-1)
+		if c.maxBytes > 0 {
+			c.bytes.Add(-c.sizeOf(itemCode, price))
+		}
+	}
+	delete(s.prices, itemCode)
+	delete(s.expirationByItem, itemCode)
+	delete(s.compactAt, itemCode)
+	delete(s.jitterByItem, itemCode)
+	delete(s.ttlByItem, itemCode)
+	delete(s.versionByItem, itemCode)
+	delete(s.stableByItem, itemCode)
+	delete(s.writeByItem, itemCode)
+	delete(s.costByItem, itemCode)
+	delete(s.tagByItem, itemCode)
+	delete(s.rawByItem, itemCode)
+	if c.recency != nil {
+		c.recency.remove(itemCode)
+	}
+}
+
+// Get how long the price for the item is fresh, the lock of the shard must be held
+func (c *TransparentCache[V]) maxAgeFor(s *shard[V], itemCode string) time.Duration {
+	if ttl, ok := s.ttlByItem[itemCode]; ok {
+		return ttl
+	}
+	var tierTTL time.Duration
+	if c.ttlClassifier != nil {
+		tierTTL = c.ttlClassifier(itemCode)
+	}
+	maxAge := c.MaxAge()
+	if tierTTL > 0 {
+		maxAge = tierTTL
+	} else if c.adaptiveMin > 0 {
+		return c.adaptiveTTL(s.stableByItem[itemCode])
+	}
+	if factor, ok := s.jitterByItem[itemCode]; ok {
+		return time.Duration(float64(maxAge) * factor)
+	}
+	return maxAge
+}
+
+// MaxAge returns how long cached prices are fresh, for the items without a TTL of their own
+func (c *TransparentCache[V]) MaxAge() time.Duration {
+	return time.Duration(c.maxAge.Load())
+}
+
+// SetMaxAge changes how long cached prices are fresh, for the items without a TTL of their own
+// Freshness is worked out when a price is read, so a shorter maxAge makes the older prices stale on their next read
+// and a longer one makes expired prices fresh again, as long as they were not removed meanwhile
+func (c *TransparentCache[V]) SetMaxAge(maxAge time.Duration) {
+	c.maxAge.Store(int64(maxAge))
+}
+
+// Tell whether a price got at cachedAt is still fresh at now, a maxAge not above zero never expires
+func isFresh(cachedAt time.Time, maxAge time.Duration, now time.Time) bool {
+	return maxAge <= 0 || cachedAt.Add(maxAge).After(now)
+}
+
+// Get a non expired price from the cache
+func (c *TransparentCache[V]) getCachedPrice(itemCode string) (V, bool) {
+	price, cachedAt, maxAge, ok := c.lookup(itemCode)
+	if !ok || !isFresh(cachedAt, maxAge, c.clock.Now()) {
+		var zero V
+		return zero, false
+	}
+	return price, true
+}
+
+// Remember the service error for the item when negative caching is enabled
+func (c *TransparentCache[V]) storeFailure(itemCode string, err error) {
+	// with a not found detector only not found items are worth remembering, the service may be back any time
+	// a timed out call tells nothing about the item either
+	if c.negativeTTL <= 0 || errors.Is(err, ErrServiceTimeout) || (c.isNotFound != nil && !errors.Is(err, ErrItemNotFound)) {
+		return
+	}
+	s := c.shardFor(itemCode)
+	s.Lock()
+	defer s.Unlock()
+	s.failures[itemCode] = failure{err: err, cachedAt: c.clock.Now()}
+}
+
+// Get a service error remembered for the item which is not older than negativeTTL
+func (c *TransparentCache[V]) getCachedFailure(itemCode string) (error, bool) {
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	f, ok := s.failures[itemCode]
+	if !ok || !f.cachedAt.Add(c.negativeTTL).After(c.clock.Now()) {
+		return nil, false
+	}
+	return f.err, true
+}
+
+// Get the cached price, when it was stored and for how long it is fresh, expired or not,
+// reading the maps under the read lock of its shard
+func (c *TransparentCache[V]) lookup(itemCode string) (V, time.Time, time.Duration, bool) {
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	price, ok := s.prices[itemCode]
+	if !ok {
+		return price, time.Time{}, 0, false
+	}
+	c.touch(s, itemCode)
+	c.noteRead(s, itemCode)
+	cachedAt, _ := s.cachedAt(itemCode)
+	return price, cachedAt, c.maxAgeFor(s, itemCode), true
+}
+
+// Peek returns the cached price for the item without ever calling the service
+// ok tells whether the item is cached at all and fresh whether its price is not older than maxAge
+// Peeking doesn't count as using the item for the least recently used eviction
+func (c *TransparentCache[V]) Peek(itemCode string) (price V, fresh bool, ok bool) {
+	itemCode = c.key(itemCode)
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	price, ok = s.prices[itemCode]
+	if !ok {
+		return price, false, false
+	}
+	cachedAt, _ := s.cachedAt(itemCode)
+	return price, isFresh(cachedAt, c.maxAgeFor(s, itemCode), c.clock.Now()), true
+}
+
+// ExpiresAt returns when the cached price for the item becomes stale, and whether the item is cached
+// The time is zero for prices which never expire
+func (c *TransparentCache[V]) ExpiresAt(itemCode string) (time.Time, bool) {
+	itemCode = c.key(itemCode)
+	_, expiresAt, ok := c.entryFor(itemCode)
+	return expiresAt, ok
+}
+
+// Age returns how long ago the cached price for the item was got, and whether the item is cached
+func (c *TransparentCache[V]) Age(itemCode string) (time.Duration, bool) {
+	itemCode = c.key(itemCode)
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	cachedAt, ok := s.cachedAt(itemCode)
+	if !ok {
+		return 0, false
+	}
+	return c.clock.Now().Sub(cachedAt), true
+}
+
+// Len returns the number of cached prices, expired or not
+// As Keys it only counts the items in the default currency
+func (c *TransparentCache[V]) Len() int {
+	withCurrencies := c.currencies.any()
+	c.rlockAll()
+	defer c.runlockAll()
+	count := 0
+	for _, s := range c.shards {
+		if !withCurrencies {
+			count += len(s.prices)
+			continue
+		}
+		for itemCode := range s.prices {
+			if _, currency := splitKey(itemCode); currency == "" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Keys returns the codes of the cached items, expired or not, in no particular order
+// Only the items in the default currency are listed, the prices in other currencies are seen in DumpState
+func (c *TransparentCache[V]) Keys() []string {
+	c.rlockAll()
+	defer c.runlockAll()
+	keys := make([]string, 0, c.entries.Load())
+	for _, s := range c.shards {
+		for itemCode := range s.prices {
+			if _, currency := splitKey(itemCode); currency == "" {
+				keys = append(keys, itemCode)
+			}
+		}
+	}
+	return keys
+}
+
+// ForEach calls fn with every cached item, its price and when it becomes stale, until fn returns false
+// As Keys it only visits the items in the default currency
+// The item codes are listed up front, and each entry is then read under a brief lock of its own, so entries might
+// change between calls: items removed meanwhile are skipped and items added meanwhile are not visited
+// The expiration time is zero for prices which never expire
+func (c *TransparentCache[V]) ForEach(fn func(itemCode string, price V, expiresAt time.Time) bool) {
+	for _, itemCode := range c.Keys() {
+		price, expiresAt, ok := c.entryFor(itemCode)
+		if !ok {
+			continue
+		}
+		if !fn(itemCode, price, expiresAt) {
+			return
+		}
+	}
+}
+
+// Read the cached price for the item and when it becomes stale
+func (c *TransparentCache[V]) entryFor(itemCode string) (V, time.Time, bool) {
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	price, ok := s.prices[itemCode]
+	if !ok {
+		return price, time.Time{}, false
+	}
+	if maxAge := c.maxAgeFor(s, itemCode); maxAge > 0 {
+		cachedAt, _ := s.cachedAt(itemCode)
+		return price, cachedAt.Add(maxAge), true
+	}
+	return price, time.Time{}, true
+}
+
+// Invalidate removes the cached price for the item, so the next GetPriceFor gets it from the service
+// The prices of the item in every currency are removed, it returns whether there was any
+func (c *TransparentCache[V]) Invalidate(itemCode string) bool {
+	var evicted []string
+	for _, key := range c.currencies.keys(c.key(itemCode)) {
+		s := c.shardFor(key)
+		s.Lock()
+		evicted = c.invalidateLocked(s, key, evicted)
+		s.Unlock()
+	}
+	c.checkInvariants()
+	c.emitEvictions(evicted)
+	return len(evicted) > 0
+}
+
+// InvalidateAll removes every cached price at once
+func (c *TransparentCache[V]) InvalidateAll() {
+	var evicted []string
+	c.lockAll()
+	defer func() {
+		c.unlockAll()
+		c.checkInvariants()
+		c.emitEvictions(evicted)
+	}()
+	for _, s := range c.shards {
+		if c.eventHook != nil || c.logger != nil {
+			for itemCode := range s.prices {
+				evicted = append(evicted, itemCode)
+			}
+		}
+		c.stats.evictions.Add(uint64(len(s.prices)))
+		c.entries.Add(-int64(len(s.prices)))
+		s.reset()
+	}
+	// every price is gone and every shard is locked, so nobody can be adding to it
+	c.bytes.Store(0)
+	if c.recency != nil {
+		c.recency.clear()
+	}
+}
+
+// InvalidateMany removes the cached prices for the items at once, as Invalidate does for each of them
+// Every shard is locked meanwhile, so no other call sees some of them removed and not the others
+// It returns how many cached prices were removed, counting the ones of every currency of the items
+func (c *TransparentCache[V]) InvalidateMany(itemCodes ...string) int {
+	var evicted []string
+	c.lockAll()
+	for _, itemCode := range c.keys(itemCodes) {
+		for _, key := range c.currencies.keys(itemCode) {
+			evicted = c.invalidateLocked(c.shardFor(key), key, evicted)
+		}
+	}
+	c.unlockAll()
+	c.checkInvariants()
+	c.emitEvictions(evicted)
+	return len(evicted)
+}
+
+// InvalidatePrefix removes at once the cached prices for every item whose code starts with prefix
+// It returns how many prices were removed
+func (c *TransparentCache[V]) InvalidatePrefix(prefix string) int {
+	prefix = c.key(prefix)
+	var evicted []string
+	c.lockAll()
+	for _, s := range c.shards {
+		for itemCode := range s.prices {
+			if strings.HasPrefix(itemCode, prefix) {
+				evicted = c.invalidateLocked(s, itemCode, evicted)
+			}
+		}
+	}
+	c.unlockAll()
+	c.checkInvariants()
+	c.emitEvictions(evicted)
+	return len(evicted)
+}
+
+// Remove the item as Invalidate does, appending it to evicted when it had a cached price, the lock must be held
+func (c *TransparentCache[V]) invalidateLocked(s *shard[V], itemCode string, evicted []string) []string {
+	if _, ok := s.prices[itemCode]; ok {
+		c.stats.evictions.Add(1)
+		evicted = append(evicted, itemCode)
+	}
+	c.removeEntry(s, itemCode)
+	delete(s.failures, itemCode)
+	return evicted
+}
+
+// serviceResult is the outcome of a single call to the actual service
+type serviceResult[V any] struct {
+	price V
+	err   error
+}
+
+// Call the actual service, reporting how long the call took to the event hook
+// and to the circuit breaker, which may not let the call through, waiting for its turn when rate limited
+func (c *TransparentCache[V]) fetchPrice(ctx context.Context, itemCode string) (V, error) {
+	var zero V
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return zero, err
+		}
+	}
+	if c.breaker != nil && !c.breaker.allow() {
+		return zero, ErrCircuitOpen
+	}
+	ctx, span := c.startSpan(ctx, "cache.service.GetPriceFor", itemCode)
+	callCtx, cancel := c.withItemTimeout(ctx, itemCode)
+	start := c.clock.Now()
+	price, err := c.callService(callCtx, c.service(), itemCode)
+	cancel()
+	err = timeoutError(ctx, callCtx, err)
+	c.emitServiceCall(itemCode, start, err)
+	c.reportError(ctx, []string{itemCode}, err)
+	endServiceSpan(span, err)
+	if c.breaker != nil {
+		c.breaker.record(err)
+	}
+	return price, err
+}
+
+// Call the actual service, passing ctx down when it is supported or waiting on ctx otherwise
+// A panicking service fails the call with ErrServicePanic
+func (c *TransparentCache[V]) callService(ctx context.Context, service *backend[V], itemCode string) (V, error) {
+	c.activeFetches.Add(1)
+	if service.getContext != nil {
+		defer c.activeFetches.Add(-1)
+		return guarded(func() (V, error) { return service.getContext(ctx, itemCode) })
+	}
+	// a call given up on is still in flight until the service returns
+	return detached(ctx, func() (V, error) {
+		defer c.activeFetches.Add(-1)
+		return service.get(itemCode)
+	})
+}
+
+// Run a call which can't be cancelled, returning as soon as ctx is done
+// A panic of the call is recovered as guarded does, even when it runs on its own goroutine
+func detached[V any](ctx context.Context, get func() (V, error)) (V, error) {
+	if ctx.Done() == nil {
+		return guarded(get)
+	}
+	// buffered so the call can finish and be discarded after ctx is done
+	result := make(chan serviceResult[V], 1)
+	go func() {
+		price, err := guarded(get)
+		result <- serviceResult[V]{price: price, err: err}
+	}()
+	select {
+	case r := <-result:
+		return r.price, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
 	}
-	c.Lock()
-	defer c.Unlock()
-	c.prices[itemCode] = price
-	c.expirationByItem[itemCode] = time.Now()
-	return price, nil
 }
 
 // GetPricesFor gets the prices for several items at once, some might be found in the cache, others might not
-// If any of the operations returns an error, it should return an error as well
-func (c *TransparentCache) GetPricesFor(itemCodes ...string) ([]float64, error) {
-	var w sync.WaitGroup
-	output := make(chan []float64)
-	input := make(chan float64)
-	errOutput := make(chan error)
-	defer close(output)
-
-	go c.handleResults(input, output, &w)
-	for _, itemCode := range itemCodes {
-		w.Add(1)
-		go c.getConcurrentPrice(input, itemCode, errOutput)
+// The returned prices are in the same order as the requested item codes
+// If any of the operations returns an error, it should return an error as well, and a nil slice
+// so the prices are either all there or none is
+// At most maxConcurrency prices are fetched at once, by a pool of workers
+// Each item is looked up live when its turn comes, unless WithSnapshotBatches is set
+func (c *TransparentCache[V]) GetPricesFor(itemCodes ...string) ([]V, error) {
+	return c.collectAll(c.keys(itemCodes))
+}
+
+// GetPricesForSlice gets the prices for several items at once as GetPricesFor does, for callers holding a slice
+// The slice is only read, it is neither kept nor modified
+func (c *TransparentCache[V]) GetPricesForSlice(itemCodes []string) ([]V, error) {
+	return c.collectAll(c.keys(itemCodes))
+}
+
+// Get the prices of a batch, all of them or none when any fails, the item codes are already normalized
+func (c *TransparentCache[V]) collectAll(itemCodes []string) ([]V, error) {
+	// a single item needs no workers, unless a batch service would be asked for it
+	if len(itemCodes) == 1 && (c.service().getBatch == nil || c.bypass.Load()) {
+		price, _, err := c.getPrice(context.Background(), itemCodes[0])
+		if err != nil {
+			return nil, newMultiError([]ItemError{{Index: 0, ItemCode: itemCodes[0], Err: err}})
+		}
+		return []V{price}, nil
+	}
+	prices, err := c.collect(context.Background(), itemCodes)
+	if err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// GetPricesForContext gets the prices for several items at once as GetPricesFor does, bounded by ctx
+// When ctx is done the fetches in flight are cancelled, and the prices already got are returned along with ctx.Err()
+// Otherwise a failing item fails the batch as in GetPricesFor, with a nil slice and the MultiError
+func (c *TransparentCache[V]) GetPricesForContext(ctx context.Context, itemCodes ...string) ([]V, error) {
+	results, err := c.collect(ctx, c.keys(itemCodes))
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return results, ctxErr
 	}
-	w.Wait()
-	close(input)
-	err := <-errOutput
 	if err != nil {
-		close(errOutput)
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetPricesForResult gets the prices for several items at once, recording the outcome of each item on its own
+// A failing item never aborts the batch, the prices that were got are returned along with the error of each failing item
+func (c *TransparentCache[V]) GetPricesForResult(itemCodes ...string) (prices map[string]V, errs map[string]error) {
+	prices = map[string]V{}
+	errs = map[string]error{}
+	var mu sync.Mutex
+	c.fetchAll(context.Background(), c.keys(itemCodes), func(index int, price V, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs[itemCodes[index]] = err
+			return
+		}
+		prices[itemCodes[index]] = price
+	})
+	return prices, errs
+}
+
+// GetPricesForBestEffort gets the prices for several items at once, returning the ones got by the time ctx is done
+// along with the item codes still being got, the failing items are in neither of them
+// The fetches still in flight are not cancelled by ctx, they complete in the background and cache their prices
+func (c *TransparentCache[V]) GetPricesForBestEffort(ctx context.Context, itemCodes ...string) (map[string]V, []string) {
+	prices := make(map[string]V, len(itemCodes))
+	completed := make(map[string]bool, len(itemCodes))
+	var mu sync.Mutex
+	if !c.addBackground() {
+		// a closed cache fails every item
+		return prices, nil
+	}
+	allDone := make(chan struct{})
+	go func() {
+		defer c.background.Done()
+		defer close(allDone)
+		c.fetchAll(c.lifetime, c.keys(itemCodes), func(index int, price V, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if prices == nil {
+				// the caller is gone, the price was only cached
+				return
+			}
+			completed[itemCodes[index]] = true
+			if err == nil {
+				prices[itemCodes[index]] = price
+			}
+		})
+	}()
+	select {
+	case <-allDone:
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	got := prices
+	var pending []string
+	for _, itemCode := range itemCodes {
+		if !completed[itemCode] {
+			pending = append(pending, itemCode)
+			completed[itemCode] = true
+		}
 	}
-	return <-output, err
+	prices = nil
+	return got, pending
 }
 
-// Handle price input channels and output prices channel
-func (c *TransparentCache) handleResults(input chan float64, output chan []float64, wg *sync.WaitGroup) {
-	var results []float64
-	for result := range input {
-		results = append(results, result)
-		wg.Done()
+// GetMany gets the prices for several items at once as GetPricesFor does, mapped by item code
+// On error the map still has the prices that were got, and the error is the one of the first failing item code
+func (c *TransparentCache[V]) GetMany(itemCodes ...string) (map[string]V, error) {
+	prices := make(map[string]V, len(itemCodes))
+	var mu sync.Mutex
+	errIndex := len(itemCodes)
+	var firstErr error
+	c.fetchAll(context.Background(), c.keys(itemCodes), func(index int, price V, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			prices[itemCodes[index]] = price
+		} else if index < errIndex {
+			errIndex, firstErr = index, err
+		}
+	})
+	return prices, firstErr
+}
+
+// IndexedPrice is the outcome for an item code of GetPricesForStream, with its position in the requested item codes
+type IndexedPrice[V any] struct {
+	Index    int
+	ItemCode string
+	Price    V
+	Err      error
+}
+
+// GetPricesForStream gets the prices for several items at once as GetPricesFor does, sending each one as soon as it is ready
+// Every item code gets exactly one result, in no particular order, and the channel is closed after the last one
+func (c *TransparentCache[V]) GetPricesForStream(itemCodes ...string) <-chan IndexedPrice[V] {
+	// buffered so the workers exit even if the caller stops receiving
+	output := make(chan IndexedPrice[V], len(itemCodes))
+	go func() {
+		defer close(output)
+		c.fetchAll(context.Background(), c.keys(itemCodes), func(index int, price V, err error) {
+			output <- IndexedPrice[V]{Index: index, ItemCode: itemCodes[index], Price: price, Err: err}
+		})
+	}()
+	return output
+}
+
+// Get the prices of a batch straight into a slice, placing each price at its original index
+// When any item fails a MultiError is returned, with the errors of all the failing item codes by index
+func (c *TransparentCache[V]) collect(ctx context.Context, itemCodes []string) ([]V, error) {
+	results := make([]V, len(itemCodes))
+	var mu sync.Mutex
+	var errs []ItemError
+	c.fetchAll(ctx, itemCodes, func(index int, price V, err error) {
+		// every index is delivered once, so workers never write the same element
+		results[index] = price
+		if err == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, ItemError{Index: index, ItemCode: itemCodes[index], Err: err})
+	})
+	if len(errs) > 0 {
+		return results, newMultiError(errs)
 	}
-	output <- results
+	return results, nil
 }
 
-// Get concurrent price into output channel or through an error into error channel
-func (c *TransparentCache) getConcurrentPrice(input chan float64, itemCode string, errOutput chan error) {
-	price, err := c.GetPriceFor(itemCode)
-	input <- price
-	errOutput <- err
+// Get the prices with the pool of workers, calling deliver once per item code with its result, and wait for all of them
+// Repeated item codes are got once, and the result is delivered for every position asking for it
+// Every fetch is bounded by ctx, so the workers exit soon after it is done
+// deliver is called from several workers at once
+func (c *TransparentCache[V]) fetchAll(ctx context.Context, itemCodes []string, deliver func(index int, price V, err error)) {
+	groups := groupIndexes(itemCodes)
+	if c.snapshotBatches && !c.bypass.Load() {
+		groups = c.answerFromSnapshot(itemCodes, groups, deliver)
+		if len(groups.firsts) == 0 {
+			return
+		}
+	}
+	// the batch path answers from the cache, so prices are got one by one while bypassing it
+	if service := c.service(); service.getBatch != nil && !c.bypass.Load() {
+		c.fetchBatch(ctx, service, itemCodes, groups, deliver)
+		return
+	}
+	jobs := make(chan int, len(groups.firsts))
+	for _, first := range groups.firsts {
+		jobs <- first
+	}
+	close(jobs)
+	workers := c.maxConcurrency
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			c.priceWorker(ctx, jobs, groups, itemCodes, deliver)
+		}()
+	}
+	wg.Wait()
+}
+
+// itemGroups are the positions of each distinct item code of a batch, as lists linked through next
+// so a batch without repeated item codes takes just two slices, however big it is
+type itemGroups struct {
+	firsts []int // first position of each distinct item code, in order of first appearance
+	next   []int // next position with the same item code, -1 after the last one
+}
+
+// Group the positions of each distinct item code, in order of first appearance
+func groupIndexes(itemCodes []string) itemGroups {
+	groups := itemGroups{firsts: make([]int, 0, len(itemCodes)), next: make([]int, len(itemCodes))}
+	lastByItem := make(map[string]int, len(itemCodes))
+	for i, itemCode := range itemCodes {
+		groups.next[i] = -1
+		if last, ok := lastByItem[itemCode]; ok {
+			groups.next[last] = i
+		} else {
+			groups.firsts = append(groups.firsts, i)
+		}
+		lastByItem[itemCode] = i
+	}
+	return groups
+}
+
+// Call fn with every position of the group starting at first
+func (g itemGroups) each(first int, fn func(index int)) {
+	for index := first; index >= 0; index = g.next[index] {
+		fn(index)
+	}
+}
+
+// Drain the jobs channel, getting the price for the item code of each group, already normalized
+// Every fetch gets ctx, so the calls in flight are cancelled with it and the jobs left never call the service
+func (c *TransparentCache[V]) priceWorker(ctx context.Context, jobs chan int, groups itemGroups, itemCodes []string,
+	deliver func(index int, price V, err error)) {
+	for first := range jobs {
+		price, _, err := c.getPrice(ctx, itemCodes[first])
+		groups.each(first, func(index int) { deliver(index, price, err) })
+	}
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -15,6 +20,7 @@ type mockResult struct {
 }
 
 type mockPriceService struct {
+	mu          sync.Mutex
 	numCalls    int
 	mockResults map[string]mockResult // what price and err to return for a particular itemCode
 	callDelay   time.Duration         // how long to sleep on each call so that we can simulate calls to be expensive
@@ -22,21 +28,73 @@ type mockPriceService struct {
 
 func (m *mockPriceService) GetPriceFor(itemCode string) (float64, error) {
 
-	m.numCalls++            // increase the number of calls
+	m.mu.Lock()
+	m.numCalls++ // increase the number of calls
+	m.mu.Unlock()
 	time.Sleep(m.callDelay) // sleep to simulate expensive call
 
+	m.mu.Lock()
 	result, ok := m.mockResults[itemCode]
+	m.mu.Unlock()
 	if !ok {
 		panic(fmt.Errorf("bug in the tests, we didn't have a mock result for [%v]", itemCode))
 	}
 	return result.price, result.err
 }
 
+func (m *mockPriceService) setResult(itemCode string, result mockResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mockResults[itemCode] = result
+}
+
 func (m *mockPriceService) getNumCalls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.numCalls
 }
 
-func getPriceWithNoErr(t *testing.T, cache *TransparentCache, itemCode string) float64 {
+// mockContextPriceService blocks every call until the context given to it is done
+type mockContextPriceService struct {
+	mockPriceService
+	cancelled chan struct{}
+}
+
+func (m *mockContextPriceService) GetPriceForContext(ctx context.Context, itemCode string) (float64, error) {
+	<-ctx.Done()
+	close(m.cancelled)
+	return 0, ctx.Err()
+}
+
+// peakPriceService tracks how many calls are in flight at once
+type peakPriceService struct {
+	mu        sync.Mutex
+	inFlight  int
+	peak      int
+	callDelay time.Duration
+}
+
+func (m *peakPriceService) GetPriceFor(itemCode string) (float64, error) {
+	m.mu.Lock()
+	m.inFlight++
+	if m.inFlight > m.peak {
+		m.peak = m.inFlight
+	}
+	m.mu.Unlock()
+	time.Sleep(m.callDelay)
+	m.mu.Lock()
+	m.inFlight--
+	m.mu.Unlock()
+	return 1, nil
+}
+
+func (m *peakPriceService) getPeak() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peak
+}
+
+func getPriceWithNoErr(t *testing.T, cache *PriceCache, itemCode string) float64 {
 	price, err := cache.GetPriceFor(itemCode)
 	if err != nil {
 		t.Error("error getting price for", itemCode)
@@ -44,7 +102,7 @@ func getPriceWithNoErr(t *testing.T, cache *TransparentCache, itemCode string) f
 	return price
 }
 
-func getPricesWithNoErr(t *testing.T, cache *TransparentCache, itemCodes ...string) []float64 {
+func getPricesWithNoErr(t *testing.T, cache *PriceCache, itemCodes ...string) []float64 {
 	prices, err := cache.GetPricesFor(itemCodes...)
 	if err != nil {
 		t.Error("error getting prices for", itemCodes)
@@ -86,7 +144,7 @@ func TestGetPriceFor_CachesResults(t *testing.T) {
 			"p1": {price: 5, err: nil},
 		},
 	}
-	cache := NewTransparentCache(mockService, time.Minute)
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
 	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
 	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
 	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
@@ -100,7 +158,7 @@ func TestGetPriceFor_ReturnsErrorOnServiceError(t *testing.T) {
 			"p1": {price: 0, err: fmt.Errorf("some error")},
 		},
 	}
-	cache := NewTransparentCache(mockService, time.Minute)
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
 	_, err := cache.GetPriceFor("p1")
 	if err == nil {
 		t.Errorf("expected error, got nil")
@@ -115,7 +173,7 @@ func TestGetPriceFor_ReturnsWellKnowErrorOnServiceError(t *testing.T) {
 			"p1": {price: 0, err: fmt.Errorf("some error")},
 		},
 	}
-	cache := NewTransparentCache(mockService, time.Minute)
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
 	_, err := cache.GetPriceFor("p1")
 	errMsg := fmt.Sprintf("%v", err.Error())
 	if err == nil || !strings.Contains(errMsg, "getting price from service : ") {
@@ -123,6 +181,84 @@ func TestGetPriceFor_ReturnsWellKnowErrorOnServiceError(t *testing.T) {
 	}
 }
 
+// notFoundError is a typed service error, which callers might look for with errors.As
+type notFoundError struct {
+	itemCode string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("no price for [%v]", e.itemCode)
+}
+
+// Check that the service error is kept in the chain of the returned error
+func TestGetPriceFor_WrapsServiceError(t *testing.T) {
+	errCustom := errors.New("custom error")
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 0, err: errCustom},
+			"p2": {price: 0, err: &notFoundError{itemCode: "p2"}},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	_, err := cache.GetPriceFor("p1")
+	if !errors.Is(err, errCustom) || !errors.Is(err, ErrServiceFailure) {
+		t.Errorf("expected the custom error wrapped in ErrServiceFailure, got %v", err)
+	}
+	assertString(t, "getting price from service : custom error", err.Error(), "wrong error message")
+	_, err = cache.GetPriceFor("p2")
+	var notFound *notFoundError
+	if !errors.As(err, &notFound) || notFound.itemCode != "p2" {
+		t.Errorf("expected the not found error, got %v", err)
+	}
+}
+
+// panickingBatchService answers single calls with its function, but panics on every batch call
+type panickingBatchService struct {
+	priceFunc
+}
+
+func (m panickingBatchService) GetPricesFor(itemCodes []string) (map[string]float64, error) {
+	panic("batch endpoint is broken")
+}
+
+// Check that a panicking service fails the calls for its items without hanging the others
+func TestGetPriceFor_RecoversServicePanics(t *testing.T) {
+	service := priceFunc(func(itemCode string) (float64, error) {
+		if itemCode == "p2" {
+			panic(fmt.Errorf("nil pointer somewhere"))
+		}
+		return 5, nil
+	})
+	cache := NewTransparentCache(service, WithMaxConcurrency(2))
+	if _, err := cache.GetPriceFor("p2"); !errors.Is(err, ErrServicePanic) || !errors.Is(err, ErrServiceFailure) {
+		t.Errorf("expected a service panic, got %v", err)
+	}
+	if _, err := cache.GetPricesFor("p1", "p2", "p3"); !errors.Is(err, ErrServicePanic) {
+		t.Errorf("expected a service panic, got %v", err)
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p3"), "wrong price returned")
+
+	batchCache := NewTransparentCache(panickingBatchService{service})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := batchCache.GetPricesForContext(ctx, "p1", "p3"); !errors.Is(err, ErrServicePanic) {
+		t.Errorf("expected a service panic from the batch, got %v", err)
+	}
+}
+
+func assertFloatsInOrder(t *testing.T, expected []float64, actual []float64, msg string) {
+	if len(expected) != len(actual) {
+		t.Error(msg, fmt.Sprintf("expected : %v, got : %v", expected, actual))
+		return
+	}
+	for i, expectedValue := range expected {
+		if expectedValue != actual[i] {
+			t.Error(msg, fmt.Sprintf("expected : %v, got : %v", expected, actual))
+			return
+		}
+	}
+}
+
 // Check that cache can return more than one price at once, caching appropriately
 func TestGetPricesFor_GetsSeveralPricesAtOnceAndCachesThem(t *testing.T) {
 	mockService := &mockPriceService{
@@ -131,7 +267,7 @@ func TestGetPricesFor_GetsSeveralPricesAtOnceAndCachesThem(t *testing.T) {
 			"p2": {price: 7, err: nil},
 		},
 	}
-	cache := NewTransparentCache(mockService, time.Minute)
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
 	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
 	assertFloats(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "p1", "p2"), "wrong price returned")
 	assertFloats(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "p1", "p2"), "wrong price returned")
@@ -148,7 +284,7 @@ func TestGetPriceFor_DoesNotReturnOldResults(t *testing.T) {
 	}
 	maxAge := time.Millisecond * 200
 	maxAge70Pct := time.Millisecond * 140
-	cache := NewTransparentCache(mockService, maxAge)
+	cache := NewTransparentCache(mockService, WithMaxAge(maxAge))
 	// get price for "p1" twice (one external service call)
 	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
 	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
@@ -179,7 +315,7 @@ func TestGetPricesFor_ParallelizeCalls(t *testing.T) {
 			"p2": {price: 7, err: nil},
 		},
 	}
-	cache := NewTransparentCache(mockService, time.Minute)
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
 	start := time.Now()
 	assertFloats(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "p1", "p2"), "wrong price returned")
 	elapsedTime := time.Since(start)
@@ -187,3 +323,1141 @@ func TestGetPricesFor_ParallelizeCalls(t *testing.T) {
 		t.Error("calls took too long, expected them to take a bit over one second")
 	}
 }
+
+// Check that concurrent calls for overlapping items are safe (run with -race)
+func TestGetPriceFor_ConcurrentAccess(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	expected := map[string]float64{"p1": 5, "p2": 7, "p3": 9}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	var w sync.WaitGroup
+	for i := 0; i < 300; i++ {
+		itemCode := fmt.Sprintf("p%d", i%3+1)
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			assertFloat(t, expected[itemCode], getPriceWithNoErr(t, cache, itemCode), "wrong price returned")
+		}()
+	}
+	w.Wait()
+}
+
+// Wait a bit for a condition to become true, failing if it doesn't
+func waitFor(t *testing.T, condition func() bool, msg string) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Error(msg)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Check that prices are returned in the same order as the requested item codes
+func TestGetPricesFor_PreservesInputOrder(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloat(t, 9, getPriceWithNoErr(t, cache, "p3"), "wrong price returned")
+	assertFloatsInOrder(t, []float64{7, 9, 5}, getPricesWithNoErr(t, cache, "p2", "p3", "p1"), "wrong price order")
+	assertFloatsInOrder(t, []float64{5, 7, 9}, getPricesWithNoErr(t, cache, "p1", "p2", "p3"), "wrong price order")
+}
+
+// Check that the freshness asked by the caller wins over the max age, tighter or looser
+func TestGetPriceForFresh_OverridesMaxAge(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(10*time.Minute), WithClock(clock))
+	getPriceWithNoErr(t, cache, "p1")
+	clock.Advance(2 * time.Minute)
+	// looser than it is old
+	if _, err := cache.GetPriceForFresh("p1", 5*time.Minute); err != nil {
+		t.Fatal("error getting fresh price", err)
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	// tighter than it is old, while still fresh for the cache
+	if _, err := cache.GetPriceForFresh("p1", time.Minute); err != nil {
+		t.Fatal("error getting fresh price", err)
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	// looser than the max age
+	clock.Advance(30 * time.Minute)
+	if _, err := cache.GetPriceForFresh("p1", time.Hour); err != nil {
+		t.Fatal("error getting fresh price", err)
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that while bypassing the cache every call goes to the service, and the cache is warm again afterwards
+func TestSetBypass_CallsServiceEveryTime(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	cache.SetBypass(true)
+	mockService.setResult("p1", mockResult{price: 7})
+	for i := 0; i < 3; i++ {
+		assertFloat(t, 7, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	}
+	assertFloatsInOrder(t, []float64{7, 7}, getPricesWithNoErr(t, cache, "p1", "p1"), "wrong prices returned")
+	assertInt(t, 5, mockService.getNumCalls(), "wrong number of service calls")
+	cache.SetBypass(false)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 5, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that GetPriceForFresh neither reads nor writes the cache while bypassing it
+func TestSetBypass_GetPriceForFresh(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	cache.SetBypass(true)
+	mockService.setResult("p1", mockResult{price: 7})
+	price, err := cache.GetPriceForFresh("p1", time.Minute)
+	if err != nil {
+		t.Fatal("error getting price", err)
+	}
+	assertFloat(t, 7, price, "wrong price returned while bypassing")
+	cache.SetBypass(false)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "price got while bypassing was cached")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that GetPriceForOrDefault returns the price, or the stale one, or the fallback
+func TestGetPriceForOrDefault_DegradesGracefully(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	assertFloat(t, 5, cache.GetPriceForOrDefault("p1", -1), "wrong price returned")
+	assertFloat(t, -1, cache.GetPriceForOrDefault("p2", -1), "wrong fallback returned")
+	clock.Advance(time.Minute)
+	mockService.setResult("p1", mockResult{price: 0, err: fmt.Errorf("p1 error")})
+	assertFloat(t, 5, cache.GetPriceForOrDefault("p1", -1), "wrong stale price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that async results are delivered once each, sharing the service calls with the callers in flight
+func TestGetPriceForAsync_DeliversResults(t *testing.T) {
+	mockService := &mockPriceService{
+		callDelay: 20 * time.Millisecond,
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	results := []<-chan PriceResult[float64]{
+		cache.GetPriceForAsync("p1"),
+		cache.GetPriceForAsync("p1"),
+		cache.GetPriceForAsync("p2"),
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	for i, expected := range []float64{5, 5, 0} {
+		result := <-results[i]
+		assertFloat(t, expected, result.Price, "wrong price returned")
+		if (result.Err != nil) != (i == 2) {
+			t.Error("wrong error returned", result.Err)
+		}
+		if _, ok := <-results[i]; ok {
+			t.Error("expected the channel to be closed after the result")
+		}
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a call that outlives its context returns the context error and is not cached
+func TestGetPriceForContext_ReturnsContextErrorAndDoesNotCache(t *testing.T) {
+	mockService := &mockPriceService{
+		callDelay: 200 * time.Millisecond,
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := cache.GetPriceForContext(ctx, "p1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 150*time.Millisecond {
+		t.Error("call didn't return when the context was done")
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that cancellation is passed down to a context aware service
+func TestGetPriceForContext_PropagatesCancellation(t *testing.T) {
+	mockService := &mockContextPriceService{cancelled: make(chan struct{})}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	_, err := cache.GetPriceForContext(ctx, "p1")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled, got %v", err)
+	}
+	select {
+	case <-mockService.cancelled:
+	case <-time.After(time.Second):
+		t.Error("service didn't observe the cancellation")
+	}
+}
+
+// hangingPriceService never answers for the hanging item, until the context of the call is done
+type hangingPriceService struct {
+	mockPriceService
+	hanging string
+}
+
+func (m *hangingPriceService) GetPriceForContext(ctx context.Context, itemCode string) (float64, error) {
+	if itemCode == m.hanging {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	return m.GetPriceFor(itemCode)
+}
+
+// Check that the whole batch is bounded by the context, returning the prices got so far without leaking goroutines
+func TestGetPricesForContext_ReturnsPartialResultsOnDeadline(t *testing.T) {
+	mockService := &hangingPriceService{
+		mockPriceService: mockPriceService{
+			mockResults: map[string]mockResult{
+				"p1": {price: 5, err: nil},
+				"p3": {price: 9, err: nil},
+			},
+		},
+		hanging: "p2",
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	prices, err := cache.GetPricesForContext(ctx, "p1", "p2", "p3")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("batch didn't return when the context was done")
+	}
+	assertFloatsInOrder(t, []float64{5, 0, 9}, prices, "wrong prices returned")
+	waitForGoroutines(t, goroutines)
+}
+
+// Check that a failing item fails the batch as in GetPricesFor when ctx is not done, with no partial results
+func TestGetPricesForContext_FailsAsGetPricesFor(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	prices, err := cache.GetPricesForContext(context.Background(), "p1", "p2")
+	if prices != nil {
+		t.Errorf("expected nil prices on error, got %v", prices)
+	}
+	_, expected := cache.GetPricesFor("p1", "p2")
+	var multiErr *MultiError
+	if !errors.As(err, &multiErr) || err.Error() != expected.Error() {
+		t.Errorf("expected %v, got %v", expected, err)
+	}
+}
+
+// Check that a single item gets the same prices and errors as the general path with its workers
+func TestGetPricesFor_SingleItemMatchesGeneralPath(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("some error")},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	for _, itemCode := range []string{"p1", "p2", " "} {
+		fast, fastErr := cache.GetPricesFor(itemCode)
+		general, generalErr := cache.collect(context.Background(), []string{itemCode})
+		if fmt.Sprint(fastErr) != fmt.Sprint(generalErr) {
+			t.Errorf("[%v] got error %v, the general path %v", itemCode, fastErr, generalErr)
+		}
+		if generalErr != nil {
+			general = nil
+		}
+		if fmt.Sprint(fast) != fmt.Sprint(general) || (fast == nil) != (general == nil) {
+			t.Errorf("[%v] got prices %v, the general path %v", itemCode, fast, general)
+		}
+	}
+}
+
+// Check that the prices got in time are returned, while the slow ones keep being got for next time
+func TestGetPricesForBestEffort_ReturnsWhatCompletedInTime(t *testing.T) {
+	service := priceFunc(func(itemCode string) (float64, error) {
+		switch itemCode {
+		case "slow1", "slow2":
+			time.Sleep(200 * time.Millisecond)
+		case "failing":
+			return 0, fmt.Errorf("some error")
+		}
+		return float64(len(itemCode)), nil
+	})
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute))
+	defer cache.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	prices, pending := cache.GetPricesForBestEffort(ctx, "fast", "slow1", "failing", "slow2", "fast")
+	if len(prices) != 1 || prices["fast"] != 4 {
+		t.Errorf("wrong prices got in time: %v", prices)
+	}
+	if fmt.Sprint(pending) != "[slow1 slow2]" {
+		t.Errorf("wrong pending items: %v", pending)
+	}
+	waitFor(t, func() bool {
+		_, ok1 := cache.getCachedPrice("slow1")
+		_, ok2 := cache.getCachedPrice("slow2")
+		return ok1 && ok2
+	}, "slow prices were not cached in the background")
+}
+
+// blockingPriceService blocks every call until its context is done, counting the calls which saw it done
+type blockingPriceService struct {
+	started   atomic.Int32
+	cancelled atomic.Int32
+}
+
+func (m *blockingPriceService) GetPriceFor(itemCode string) (float64, error) {
+	panic("bug in the tests, the context aware call was expected")
+}
+
+func (m *blockingPriceService) GetPriceForContext(ctx context.Context, itemCode string) (float64, error) {
+	m.started.Add(1)
+	<-ctx.Done()
+	m.cancelled.Add(1)
+	return 0, ctx.Err()
+}
+
+// Check that cancelling a batch reaches every service call in flight, not just the collection of the results
+func TestGetPricesForContext_CancelsCallsInFlight(t *testing.T) {
+	mockService := &blockingPriceService{}
+	cache := NewTransparentCache(mockService, WithMaxConcurrency(4))
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.GetPricesForContext(ctx, "p1", "p2", "p3", "p4", "p5", "p6")
+		done <- err
+	}()
+	waitFor(t, func() bool { return mockService.started.Load() == 4 }, "the service calls were not started")
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled, got %v", err)
+	}
+	waitFor(t, func() bool { return mockService.cancelled.Load() == 4 }, "the calls in flight didn't see the cancellation")
+	assertInt(t, 4, int(mockService.started.Load()), "items left were got after the cancellation")
+	waitForGoroutines(t, goroutines)
+}
+
+// Check that GetPricesForSlice answers as the variadic GetPricesFor, for prices and errors
+func TestGetPricesForSlice_MatchesVariadic(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	for _, itemCodes := range [][]string{{"p1", "p3", "p1"}, {"p3", "p2", "p1"}, {}} {
+		variadic, variadicErr := cache.GetPricesFor(itemCodes...)
+		slice, sliceErr := cache.GetPricesForSlice(itemCodes)
+		assertFloatsInOrder(t, variadic, slice, "wrong prices returned")
+		if fmt.Sprint(variadicErr) != fmt.Sprint(sliceErr) {
+			t.Errorf("expected error %v, got %v", variadicErr, sliceErr)
+		}
+	}
+}
+
+// Check that an error on any item of a batch is reported and no goroutine is left behind
+func TestGetPricesFor_ReturnsErrorOfFailingItem(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		prices, err := cache.GetPricesFor("p1", "p2", "p3")
+		if err == nil || !strings.Contains(err.Error(), "p2 error") {
+			t.Errorf("expected p2 error, got %v", err)
+		}
+		// no partial results, even with p1 and p3 got
+		if prices != nil {
+			t.Errorf("expected nil prices on error, got %v", prices)
+		}
+	}
+	if prices, err := cache.GetPricesForSlice([]string{"p3", "p2"}); err == nil || prices != nil {
+		t.Errorf("expected nil prices and an error, got %v and %v", prices, err)
+	}
+	// on success every price is there, in order
+	assertFloatsInOrder(t, []float64{9, 5, 9}, getPricesWithNoErr(t, cache, "p3", "p1", "p3"), "wrong prices returned")
+	waitForGoroutines(t, goroutines)
+}
+
+// Check that all the failing items are reported, in the order they were asked for, whatever order they failed in
+func TestGetPricesFor_ReportsEveryFailingItemInInputOrder(t *testing.T) {
+	errNotFound := fmt.Errorf("not found")
+	service := priceFunc(func(itemCode string) (float64, error) {
+		switch itemCode {
+		case "p1", "p4":
+			return 0, fmt.Errorf("%v error : %w", itemCode, errNotFound)
+		case "p3":
+			// fails the last, after the ones asked for later
+			time.Sleep(50 * time.Millisecond)
+			return 0, fmt.Errorf("p3 error")
+		}
+		return 5, nil
+	})
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute))
+	for i := 0; i < 5; i++ {
+		prices, err := cache.GetPricesFor("p4", "p2", "p3", "p1")
+		if prices != nil {
+			t.Errorf("expected nil prices on error, got %v", prices)
+		}
+		var multiErr *MultiError
+		if !errors.As(err, &multiErr) {
+			t.Fatalf("expected a MultiError, got %v", err)
+		}
+		var itemCodes []string
+		var indexes []int
+		for _, itemErr := range multiErr.Errors() {
+			itemCodes = append(itemCodes, itemErr.ItemCode)
+			indexes = append(indexes, itemErr.Index)
+		}
+		assertStrings(t, []string{"p4", "p3", "p1"}, itemCodes, "wrong failing items")
+		if fmt.Sprint(indexes) != "[0 2 3]" {
+			t.Errorf("expected failing indexes [0 2 3], got %v", indexes)
+		}
+		expected := "3 items failed : [p4] getting price from service : p4 error : not found ; " +
+			"[p3] getting price from service : p3 error ; [p1] getting price from service : p1 error : not found"
+		if err.Error() != expected {
+			t.Errorf("expected error %q, got %q", expected, err.Error())
+		}
+		if !errors.Is(err, errNotFound) || !errors.Is(err, ErrServiceFailure) {
+			t.Errorf("expected the errors of the items in the chain, got %v", err)
+		}
+	}
+	// a single item fails with a MultiError too
+	var multiErr *MultiError
+	if _, err := cache.GetPricesFor("p1"); !errors.As(err, &multiErr) || len(multiErr.Errors()) != 1 {
+		t.Errorf("expected a MultiError with one item, got %v", err)
+	}
+}
+
+// Wait a bit for goroutines to exit, failing if there are more than expected
+func waitForGoroutines(t *testing.T, expected int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > expected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if actual := runtime.NumGoroutine(); actual > expected {
+		t.Error("goroutines leaked", fmt.Sprintf("expected : %v, got : %v", expected, actual))
+	}
+}
+
+// Check that GetPricesFor never runs more service calls at once than the configured limit
+func TestGetPricesFor_LimitsConcurrency(t *testing.T) {
+	mockService := &peakPriceService{callDelay: 10 * time.Millisecond}
+	cache := NewTransparentCache(mockService, WithMaxConcurrency(4))
+	itemCodes := make([]string, 40)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("p%d", i)
+	}
+	prices := getPricesWithNoErr(t, cache, itemCodes...)
+	assertInt(t, 40, len(prices), "wrong number of prices returned")
+	assertInt(t, 4, mockService.getPeak(), "wrong peak of concurrent service calls")
+}
+
+// Check that an invalidated item is got from the service again
+func TestInvalidate_RefetchesItem(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	if !cache.Invalidate("p1") {
+		t.Error("expected p1 to be cached")
+	}
+	if cache.Invalidate("p3") {
+		t.Error("expected p3 not to be cached")
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that every item is got from the service again after invalidating all of them
+func TestInvalidateAll_RefetchesEveryItem(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloats(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "p1", "p2"), "wrong price returned")
+	cache.InvalidateAll()
+	assertFloats(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "p1", "p2"), "wrong price returned")
+	assertInt(t, 4, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that an expired price within the stale grace is returned while it is refreshed in the background
+func TestWithStaleWhileRevalidate_ReturnsStalePriceAndRefreshes(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStaleWhileRevalidate(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	mockService.setResult("p1", mockResult{price: 6})
+	clock.Advance(time.Minute)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong stale price returned")
+	waitFor(t, func() bool {
+		price, ok := cache.getCachedPrice("p1")
+		return ok && price == 6
+	}, "price was not refreshed in the background")
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "wrong refreshed price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	// past the stale grace the price is got from the service before returning
+	mockService.setResult("p1", mockResult{price: 7})
+	clock.Advance(2 * time.Minute)
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p1"), "wrong price returned after the stale grace")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that an expired price is returned when the service fails, only while it is within the max stale on error
+func TestWithMaxStaleOnError_ReturnsExpiredPriceOnServiceError(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithMaxStaleOnError(10*time.Minute))
+	getPricesWithNoErr(t, cache, "p1")
+	clock.Advance(8 * time.Minute)
+	getPricesWithNoErr(t, cache, "p2")
+	mockService.setResult("p1", mockResult{err: fmt.Errorf("some error")})
+	mockService.setResult("p2", mockResult{err: fmt.Errorf("some error")})
+	clock.Advance(5 * time.Minute)
+	// p1 is 13 minutes old, past the window, and p2 only 5
+	if _, err := cache.GetPriceFor("p1"); !errors.Is(err, ErrServiceFailure) {
+		t.Errorf("expected the service error for p1, got %v", err)
+	}
+	price, source, err := cache.GetPriceForWithSource("p2")
+	if err != nil || price != 7 || source != SourceStale {
+		t.Errorf("expected the stale price of p2, got %v from %v, error %v", price, source, err)
+	}
+	assertInt(t, 4, mockService.getNumCalls(), "wrong number of service calls")
+	// a successful call replaces the stale price
+	mockService.setResult("p2", mockResult{price: 8})
+	assertFloat(t, 8, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+}
+
+// Check that an expired price is returned right away however old it is, and refreshed in the background
+func TestGetPriceForEventual_ReturnsExpiredPricesRightAway(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	defer cache.Close()
+	price, err := cache.GetPriceForEventual("p1")
+	if err != nil || price != 5 {
+		t.Errorf("expected the price got from the service, got %v, error %v", price, err)
+	}
+	mockService.setResult("p1", mockResult{price: 6})
+	mockService.callDelay = 100 * time.Millisecond
+	clock.Advance(24 * time.Hour)
+	start := time.Now()
+	price, err = cache.GetPriceForEventual("p1")
+	if err != nil || price != 5 {
+		t.Errorf("expected the expired price, got %v, error %v", price, err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("the expired price was not returned right away")
+	}
+	waitFor(t, func() bool {
+		price, ok := cache.getCachedPrice("p1")
+		return ok && price == 6
+	}, "the price was not refreshed in the background")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a service error is remembered for the negative TTL instead of calling the service again
+func TestWithNegativeTTL_CachesServiceErrors(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 0, err: fmt.Errorf("some error")},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithClock(clock), WithNegativeTTL(time.Second))
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetPriceFor("p1"); err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	// once the negative TTL is over the service is called again, and a success replaces the error
+	clock.Advance(time.Second)
+	mockService.setResult("p1", mockResult{price: 5})
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the not found detector tells apart missing items from an unavailable service,
+// and that only the missing items are negatively cached
+func TestWithNotFoundDetector_ClassifiesServiceErrors(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 0, err: &notFoundError{itemCode: "p1"}},
+			"p2": {price: 0, err: fmt.Errorf("connection refused")},
+		},
+	}
+	isNotFound := func(err error) bool {
+		var notFound *notFoundError
+		return errors.As(err, &notFound)
+	}
+	cache := NewTransparentCache(mockService, WithNotFoundDetector(isNotFound), WithNegativeTTL(time.Minute))
+	for i := 0; i < 2; i++ {
+		_, err := cache.GetPriceFor("p1")
+		if !errors.Is(err, ErrItemNotFound) || !errors.Is(err, ErrServiceFailure) || errors.Is(err, ErrServiceUnavailable) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+		_, err = cache.GetPriceFor("p2")
+		if !errors.Is(err, ErrServiceUnavailable) || !errors.Is(err, ErrServiceFailure) || errors.Is(err, ErrItemNotFound) {
+			t.Errorf("expected an unavailable service error, got %v", err)
+		}
+	}
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a slow fetch doesn't overwrite a newer price set while it was running
+func TestSet_NewerPriceSurvivesSlowFetch(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+		callDelay: 50 * time.Millisecond,
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	result := cache.GetPriceForAsync("p1")
+	waitFor(t, func() bool { return mockService.getNumCalls() == 1 }, "the service was not called")
+	cache.Set("p1", 6)
+	r := <-result
+	if r.Err != nil {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+	assertFloat(t, 6, r.Price, "the fetch didn't return the newer price")
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "the newer price was overwritten")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that ForEach visits every cached entry, and stops as soon as the callback returns false
+func TestForEach_VisitsEntries(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock))
+	cache.SetMany(map[string]float64{"p1": 5, "p2": 7, "p3": 9})
+	visited := map[string]float64{}
+	cache.ForEach(func(itemCode string, price float64, expiresAt time.Time) bool {
+		visited[itemCode] = price
+		assertTime(t, clock.Now().Add(time.Minute), expiresAt, "wrong expiration for "+itemCode)
+		return true
+	})
+	if fmt.Sprint(visited) != fmt.Sprint(map[string]float64{"p1": 5, "p2": 7, "p3": 9}) {
+		t.Errorf("wrong entries visited %v", visited)
+	}
+	calls := 0
+	cache.ForEach(func(itemCode string, price float64, expiresAt time.Time) bool {
+		calls++
+		return calls < 2
+	})
+	assertInt(t, 2, calls, "wrong number of calls before stopping")
+}
+
+// Check that TryGetPriceFor never waits on the service, warming the cache for the next try instead
+func TestTryGetPriceFor_NeverBlocks(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+		callDelay: 100 * time.Millisecond,
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	defer cache.Close()
+	start := time.Now()
+	if _, ok := cache.TryGetPriceFor("p1"); ok {
+		t.Error("expected no price for an uncached item")
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("TryGetPriceFor waited on the service")
+	}
+	waitFor(t, func() bool { _, ok := cache.TryGetPriceFor("p1"); return ok }, "the price was not got in the background")
+	price, _ := cache.TryGetPriceFor("p1")
+	assertFloat(t, 5, price, "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that TryGetPriceFor neither answers from the cache nor fetches in the background while bypassing it
+func TestTryGetPriceFor_Bypassed(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	defer cache.Close()
+	cache.Set("p1", 1)
+	cache.SetBypass(true)
+	if price, ok := cache.TryGetPriceFor("p1"); ok {
+		t.Errorf("expected no price while bypassing, got %v", price)
+	}
+	if _, ok := cache.TryGetPriceFor("p2"); ok {
+		t.Error("expected no price for an uncached item")
+	}
+	time.Sleep(20 * time.Millisecond)
+	cache.SetBypass(false)
+	assertInt(t, 0, mockService.getNumCalls(), "wrong number of service calls")
+	price, _ := cache.TryGetPriceFor("p1")
+	assertFloat(t, 1, price, "cached price changed while bypassing")
+}
+
+// Check that many items, or every item of a prefix, are invalidated at once
+func TestInvalidateManyAndPrefix_RemoveMatchingItems(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{})
+	for _, itemCode := range []string{"SUP1-a", "SUP1-b", "SUP1-c", "SUP12-a", "SUP2-a", "p1", "p2"} {
+		cache.Set(itemCode, 5)
+	}
+	assertInt(t, 3, cache.InvalidatePrefix("SUP1-"), "wrong number of invalidated prices")
+	assertStrings(t, []string{"SUP12-a", "SUP2-a", "p1", "p2"}, sortedKeys(cache), "wrong cached item codes")
+	assertInt(t, 0, cache.InvalidatePrefix("SUP1-"), "wrong number of invalidated prices")
+	// uncached and repeated item codes are not counted
+	assertInt(t, 2, cache.InvalidateMany("p1", "p3", "SUP2-a", "p1"), "wrong number of invalidated prices")
+	assertStrings(t, []string{"SUP12-a", "p2"}, sortedKeys(cache), "wrong cached item codes")
+	assertInt(t, 5, int(cache.Stats().Evictions), "wrong number of evictions")
+}
+
+func TestInvalidateTag_RemovesTheTaggedItemsOnly(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{})
+	for _, itemCode := range []string{"tv", "phone", "milk", "bread", "pen"} {
+		cache.Set(itemCode, 5)
+	}
+	cache.SetTag("tv", "electronics")
+	cache.SetTag("phone", "electronics")
+	cache.SetTag("milk", "grocery")
+	cache.SetTag("bread", "grocery")
+	cache.SetTag("unknown", "grocery")
+	assertInt(t, 2, cache.InvalidateTag("electronics"), "wrong number of invalidated prices")
+	assertStrings(t, []string{"bread", "milk", "pen"}, sortedKeys(cache), "wrong cached item codes")
+	// the tag goes away with the item, so it is not tagged once cached again
+	cache.Set("tv", 6)
+	assertInt(t, 0, cache.InvalidateTag("electronics"), "wrong number of invalidated prices")
+	cache.SetTag("bread", "")
+	assertInt(t, 1, cache.InvalidateTag("grocery"), "wrong number of invalidated prices")
+	assertStrings(t, []string{"bread", "pen", "tv"}, sortedKeys(cache), "wrong cached item codes")
+}
+
+// Check that a price set by hand is returned without calling the service
+func TestSet_IsReturnedWithoutServiceCall(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	clock.Advance(50 * time.Second)
+	cache.Set("p1", 6)
+	cache.SetMany(map[string]float64{"p2": 7, "p3": 9})
+	// the set price overrides the cached one and is fresh for a whole maxAge
+	clock.Advance(50 * time.Second)
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloatsInOrder(t, []float64{7, 9}, getPricesWithNoErr(t, cache, "p2", "p3"), "wrong prices returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that Peek tells absent, fresh and stale items apart without calling the service
+func TestPeek_ReportsCachedState(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	if _, _, ok := cache.Peek("p1"); ok {
+		t.Error("expected p1 not to be cached")
+	}
+	getPriceWithNoErr(t, cache, "p1")
+	price, fresh, ok := cache.Peek("p1")
+	if !ok || !fresh {
+		t.Error("expected p1 to be cached and fresh")
+	}
+	assertFloat(t, 5, price, "wrong price peeked")
+	clock.Advance(time.Minute)
+	price, fresh, ok = cache.Peek("p1")
+	if !ok || fresh {
+		t.Error("expected p1 to be cached and stale")
+	}
+	assertFloat(t, 5, price, "wrong price peeked")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+func assertTime(t *testing.T, expected time.Time, actual time.Time, msg string) {
+	if !expected.Equal(actual) {
+		t.Error(msg, fmt.Sprintf("expected : %v, got : %v", expected, actual))
+	}
+}
+
+// Check that ExpiresAt and Age report the freshness of cached prices without calling the service
+// Check that items set at the same time with their own TTL expire at different times
+func TestSetWithTTL_OverridesMaxAge(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"spot":    {price: 1, err: nil},
+			"catalog": {price: 2, err: nil},
+			"other":   {price: 3, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	cache.SetWithTTL("spot", 10, 5*time.Second)
+	cache.SetWithTTL("catalog", 20, time.Hour)
+	cache.Set("other", 30)
+	clock.Advance(10 * time.Second)
+	assertFloat(t, 1, getPriceWithNoErr(t, cache, "spot"), "wrong price returned")
+	assertFloat(t, 20, getPriceWithNoErr(t, cache, "catalog"), "wrong price returned")
+	assertFloat(t, 30, getPriceWithNoErr(t, cache, "other"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	// the refreshed spot price keeps its TTL
+	clock.Advance(5 * time.Second)
+	assertFloat(t, 1, getPriceWithNoErr(t, cache, "spot"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	clock.Advance(time.Minute)
+	assertFloat(t, 20, getPriceWithNoErr(t, cache, "catalog"), "wrong price returned")
+	assertFloat(t, 3, getPriceWithNoErr(t, cache, "other"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the items of each tier expire after the TTL of their tier
+func TestWithTTLClassifier_ExpiresEveryTierOnItsOwn(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"HOT-p1": {price: 1, err: nil},
+			"p2":     {price: 2, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	classify := func(itemCode string) time.Duration {
+		if strings.HasPrefix(itemCode, "HOT-") {
+			return 5 * time.Second
+		}
+		return 0
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithTTLClassifier(classify))
+	getPricesWithNoErr(t, cache, "HOT-p1", "p2")
+	clock.Advance(4 * time.Second)
+	getPricesWithNoErr(t, cache, "HOT-p1", "p2")
+	assertInt(t, 2, mockService.getNumCalls(), "every price should still be fresh")
+	clock.Advance(time.Second)
+	getPricesWithNoErr(t, cache, "HOT-p1", "p2")
+	assertInt(t, 3, mockService.getNumCalls(), "only the hot price should have expired")
+	if expiresAt, ok := cache.ExpiresAt("p2"); !ok || !expiresAt.Equal(clock.Now().Add(55*time.Second)) {
+		t.Errorf("wrong expiration for p2: %v", expiresAt)
+	}
+	clock.Advance(55 * time.Second)
+	getPricesWithNoErr(t, cache, "HOT-p1", "p2")
+	assertInt(t, 5, mockService.getNumCalls(), "both prices should have expired")
+}
+
+// Check that Len and Keys follow the prices being stored and evicted
+func TestLenAndKeys_TrackCachedItems(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxEntries(2))
+	assertInt(t, 0, cache.Len(), "wrong number of cached prices")
+	assertStrings(t, []string{}, cache.Keys(), "wrong cached item codes")
+	cache.Set("p1", 5)
+	cache.Set("p2", 7)
+	assertInt(t, 2, cache.Len(), "wrong number of cached prices")
+	cache.Set("p3", 9)
+	assertInt(t, 2, cache.Len(), "wrong number of cached prices")
+	keys := cache.Keys()
+	sort.Strings(keys)
+	assertStrings(t, []string{"p2", "p3"}, keys, "wrong cached item codes")
+	// the keys are a copy
+	keys[0] = "p9"
+	if _, _, ok := cache.Peek("p2"); !ok {
+		t.Error("expected p2 to still be cached")
+	}
+	cache.Invalidate("p2")
+	assertInt(t, 1, cache.Len(), "wrong number of cached prices")
+	assertStrings(t, []string{"p3"}, cache.Keys(), "wrong cached item codes")
+}
+
+func TestExpiresAtAndAge_ReportFreshness(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	if _, ok := cache.ExpiresAt("p1"); ok {
+		t.Error("expected p1 not to be cached")
+	}
+	if _, ok := cache.Age("p1"); ok {
+		t.Error("expected p1 not to be cached")
+	}
+	cachedAt := clock.Now()
+	getPriceWithNoErr(t, cache, "p1")
+	clock.Advance(20 * time.Second)
+	expiresAt, ok := cache.ExpiresAt("p1")
+	if !ok {
+		t.Error("expected p1 to be cached")
+	}
+	assertTime(t, cachedAt.Add(time.Minute), expiresAt, "wrong expiration time")
+	age, ok := cache.Age("p1")
+	if !ok || age != 20*time.Second {
+		t.Error("wrong age", fmt.Sprintf("expected : %v, got : %v", 20*time.Second, age))
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a price read close to its expiration is refreshed once in the background
+func TestWithRefreshAhead_RefreshesBeforeExpiration(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(100*time.Second), WithClock(clock), WithRefreshAhead(0.1))
+	defer cache.Close()
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	clock.Advance(90 * time.Second)
+	// exactly at the threshold there is nothing to refresh yet
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	mockService.callDelay = 50 * time.Millisecond
+	mockService.setResult("p1", mockResult{price: 6})
+	clock.Advance(time.Second)
+	for i := 0; i < 5; i++ {
+		assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned while refreshing")
+	}
+	waitFor(t, func() bool {
+		price, _, _ := cache.Peek("p1")
+		return price == 6
+	}, "price was not refreshed in the background")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	expiresAt, _ := cache.ExpiresAt("p1")
+	assertTime(t, clock.Now().Add(100*time.Second), expiresAt, "wrong expiration time after refreshing")
+}
+
+// Check that a batch with failing items still returns the prices that were got
+func TestGetPricesForResult_ReturnsPartialResults(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+			"p3": {price: 9, err: nil},
+			"p4": {price: 0, err: fmt.Errorf("p4 error")},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	prices, errs := cache.GetPricesForResult("p1", "p2", "p3", "p4")
+	assertInt(t, 2, len(prices), "wrong number of prices")
+	assertFloat(t, 5, prices["p1"], "wrong price returned")
+	assertFloat(t, 9, prices["p3"], "wrong price returned")
+	assertInt(t, 2, len(errs), "wrong number of errors")
+	if errs["p2"] == nil || !strings.Contains(errs["p2"].Error(), "p2 error") {
+		t.Errorf("expected p2 error, got %v", errs["p2"])
+	}
+	if errs["p4"] == nil || !strings.Contains(errs["p4"].Error(), "p4 error") {
+		t.Errorf("expected p4 error, got %v", errs["p4"])
+	}
+}
+
+// Check that GetMany maps every item code to its price, keeping the prices got when some item fails
+func TestGetMany_MapsPricesByItemCode(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 0, err: fmt.Errorf("p3 error")},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	prices, err := cache.GetMany("p1", "p2", "p1")
+	if err != nil {
+		t.Fatal("error getting prices", err)
+	}
+	assertInt(t, 2, len(prices), "wrong number of prices")
+	assertFloat(t, 5, prices["p1"], "wrong price returned")
+	assertFloat(t, 7, prices["p2"], "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	prices, err = cache.GetMany("p1", "p3")
+	if err == nil || !strings.Contains(err.Error(), "p3 error") {
+		t.Errorf("expected p3 error, got %v", err)
+	}
+	assertInt(t, 1, len(prices), "wrong number of prices")
+	assertFloat(t, 5, prices["p1"], "wrong price returned")
+}
+
+// Check that the stream has one result per item code, placed by index, and is closed after the last one
+func TestGetPricesForStream_SendsEveryResult(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxConcurrency(2))
+	itemCodes := []string{"p1", "p2", "p3", "p1"}
+	seen := make([]int, len(itemCodes))
+	for result := range cache.GetPricesForStream(itemCodes...) {
+		seen[result.Index]++
+		assertString(t, itemCodes[result.Index], result.ItemCode, "wrong item code")
+		expected := map[string]float64{"p1": 5, "p2": 0, "p3": 9}[result.ItemCode]
+		assertFloat(t, expected, result.Price, "wrong price")
+		if (result.Err != nil) != (result.ItemCode == "p2") {
+			t.Error("wrong error", result.Err)
+		}
+	}
+	for i, count := range seen {
+		assertInt(t, 1, count, fmt.Sprintf("wrong number of results for index %d", i))
+	}
+}
+
+// Check that empty and white space item codes are never cached by the write paths either
+func TestSet_SkipsEmptyItemCodes(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{}, WithInitialPrices(map[string]float64{"": 1, "p0": 2}))
+	cache.Set("", 5)
+	cache.SetWithTTL(" ", 5, time.Minute)
+	cache.SetMany(map[string]float64{"\t": 5, "p1": 7})
+	assertStrings(t, []string{"p0", "p1"}, sortedKeys(cache), "wrong cached item codes")
+	if err := cache.Restore([]byte(`[{"itemCode":"","price":1,"cachedAt":"2099-01-01T00:00:00Z"}]`)); err != nil {
+		t.Fatal("error restoring snapshot", err)
+	}
+	assertInt(t, 2, cache.Len(), "wrong number of cached prices")
+}
+
+// Check that empty and white space item codes are rejected without calling the service, item by item in batches
+func TestGetPriceFor_RejectsEmptyItemCodes(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	for _, itemCode := range []string{"", " ", "\t\n"} {
+		if _, err := cache.GetPriceFor(itemCode); !errors.Is(err, ErrEmptyItemCode) {
+			t.Errorf("expected ErrEmptyItemCode for %q, got %v", itemCode, err)
+		}
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	prices, errs := cache.GetPricesForResult("p1", "", " ")
+	assertFloat(t, 5, prices["p1"], "wrong price returned")
+	if !errors.Is(errs[""], ErrEmptyItemCode) || !errors.Is(errs[" "], ErrEmptyItemCode) {
+		t.Errorf("expected ErrEmptyItemCode, got %v", errs)
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	assertInt(t, 1, cache.Len(), "wrong number of cached prices")
+
+	batchService := &mockBatchPriceService{mockPriceService: mockPriceService{mockResults: map[string]mockResult{"p1": {price: 5}}}}
+	batchCache := NewTransparentCache(batchService)
+	if _, err := batchCache.GetPricesFor("p1", " "); !errors.Is(err, ErrEmptyItemCode) {
+		t.Errorf("expected ErrEmptyItemCode, got %v", err)
+	}
+	assertInt(t, 1, len(batchService.getBatchCalls()), "wrong number of batch calls")
+	assertStrings(t, []string{"p1"}, batchService.getBatchCalls()[0], "wrong items in the batch call")
+}
+
+// Check that repeated item codes in a batch are got from the service once
+func TestGetPricesFor_DeduplicatesItemCodes(t *testing.T) {
+	mockService := &mockPriceService{
+		callDelay: 10 * time.Millisecond,
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService)
+	assertFloatsInOrder(t, []float64{5, 5, 7, 5}, getPricesWithNoErr(t, cache, "p1", "p1", "p2", "p1"), "wrong prices returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that prices stored at the same time expire spread over the jitter window
+func TestWithExpiryJitter_SpreadsExpirations(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(100*time.Second), WithClock(clock), WithExpiryJitter(0.2))
+	prices := map[string]float64{}
+	for i := 0; i < 100; i++ {
+		prices[fmt.Sprintf("p%d", i)] = float64(i)
+	}
+	cache.SetMany(prices)
+	earliest, latest := clock.Now().Add(80*time.Second), clock.Now().Add(120*time.Second)
+	expirations := map[time.Time]bool{}
+	for itemCode := range prices {
+		expiresAt, _ := cache.ExpiresAt(itemCode)
+		if expiresAt.Before(earliest) || expiresAt.After(latest) {
+			t.Error("expiration out of the jitter window", expiresAt)
+		}
+		expirations[expiresAt] = true
+	}
+	if len(expirations) < 50 {
+		t.Error("expirations are not spread", fmt.Sprintf("expected at least 50 distinct, got : %v", len(expirations)))
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// Clock tells the cache what time it is, so expiration can be tested without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock backed by the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
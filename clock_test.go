@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Check that a price becomes stale exactly when it reaches maxAge
+func TestWithClock_ExpiresExactlyAtMaxAge(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	clock.Advance(time.Minute - time.Nanosecond)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	clock.Advance(time.Nanosecond)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that shortening maxAge at runtime makes a price fresh under the old one stale on its next read
+func TestSetMaxAge_ShortensFreshness(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	clock.Advance(30 * time.Second)
+	if _, fresh, _ := cache.Peek("p1"); !fresh {
+		t.Error("expected the price to be fresh under the old max age")
+	}
+	cache.SetMaxAge(10 * time.Second)
+	assertInt(t, int(10*time.Second), int(cache.MaxAge()), "wrong max age")
+	if _, fresh, _ := cache.Peek("p1"); fresh {
+		t.Error("expected the price to be stale under the new max age")
+	}
+	mockService.setResult("p1", mockResult{price: 6})
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
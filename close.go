@@ -0,0 +1,44 @@
+package main
+
+// Close stops every background worker of the cache and waits for them to finish, then closes the subscriptions
+// With WithSnapshotOnClose the snapshot is saved then, and the error saving it is returned
+// After closing, getting prices returns ErrClosed, closing again does nothing
+func (c *TransparentCache[V]) Close() error {
+	c.backgroundMu.Lock()
+	first := !c.isClosed()
+	c.stop()
+	c.backgroundMu.Unlock()
+	c.background.Wait()
+	c.unsubscribeAll()
+	if first && c.snapshotOnClose {
+		return c.SaveSnapshot(c.snapshotFile)
+	}
+	return nil
+}
+
+// Tell whether the cache was closed
+func (c *TransparentCache[V]) isClosed() bool {
+	return c.lifetime.Err() != nil
+}
+
+// Register a background worker unless the cache is closed, the worker must call background.Done when finished
+func (c *TransparentCache[V]) addBackground() bool {
+	c.backgroundMu.Lock()
+	defer c.backgroundMu.Unlock()
+	if c.isClosed() {
+		return false
+	}
+	c.background.Add(1)
+	return true
+}
+
+// Run fn in a background worker, unless the cache is closed
+func (c *TransparentCache[V]) goBackground(fn func()) {
+	if !c.addBackground() {
+		return
+	}
+	go func() {
+		defer c.background.Done()
+		fn()
+	}()
+}
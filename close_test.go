@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Check that closing stops the background refreshes and later calls return ErrClosed
+func TestClose_StopsBackgroundWorkers(t *testing.T) {
+	mockService := &mockPriceService{
+		callDelay: 50 * time.Millisecond,
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	goroutines := runtime.NumGoroutine()
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStaleWhileRevalidate(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	clock.Advance(time.Minute)
+	// returns the stale price and starts a refresh in the background
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	if err := cache.Close(); err != nil {
+		t.Error("error closing the cache", err)
+	}
+	if _, err := cache.GetPriceFor("p1"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Error("error closing the cache twice", err)
+	}
+	waitForGoroutines(t, goroutines)
+}
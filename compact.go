@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// compactEpoch is the origin of the demoted timestamps, which cover about 68 years on each side of it
+var compactEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Demote the items every window until the cache is closed
+func (c *TransparentCache[V]) runDemotion(window time.Duration) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.demote(window)
+		case <-c.lifetime.Done():
+			return
+		}
+	}
+}
+
+// readSet holds the items read since the last demotion pass, with a lock of its own
+// as hits only take the read lock of the shard
+type readSet struct {
+	sync.Mutex
+	items map[string]struct{}
+}
+
+// Note the item as read
+func (r *readSet) add(itemCode string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.items == nil {
+		r.items = map[string]struct{}{}
+	}
+	r.items[itemCode] = struct{}{}
+}
+
+// Get the items read so far, starting over
+func (r *readSet) take() map[string]struct{} {
+	r.Lock()
+	defer r.Unlock()
+	items := r.items
+	r.items = nil
+	return items
+}
+
+// Note the cached item as read, so the next demotion pass keeps it, the lock of the shard must be held
+// for reading at least
+func (c *TransparentCache[V]) noteRead(s *shard[V], itemCode string) {
+	if c.trackReads {
+		s.reads.add(itemCode)
+	}
+}
+
+// Demote the items cached at least window ago and not read since the previous pass, which with WithDemotion
+// is window ago too, keeping when they were cached in whole seconds only
+// Times too far from compactEpoch to fit in the compact storage are kept as they are
+// Shards are locked one at a time, it returns how many items were demoted
+func (c *TransparentCache[V]) demote(window time.Duration) int {
+	demoted := 0
+	for _, s := range c.shards {
+		s.Lock()
+		now := c.clock.Now()
+		read := s.reads.take()
+		// maps never shrink, so the items left are moved to a new one for the memory to be released
+		kept := map[string]time.Time{}
+		for itemCode, cachedAt := range s.expirationByItem {
+			_, wasRead := read[itemCode]
+			// Unix rounds down, so a demoted price never looks fresher than it is
+			seconds := cachedAt.Unix() - compactEpoch.Unix()
+			if wasRead || now.Sub(cachedAt) < window || seconds < math.MinInt32 || seconds > math.MaxInt32 {
+				kept[itemCode] = cachedAt
+				continue
+			}
+			s.compactAt[itemCode] = int32(seconds)
+			demoted++
+		}
+		s.expirationByItem = kept
+		s.Unlock()
+	}
+	c.checkInvariants()
+	return demoted
+}
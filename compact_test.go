@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Check that demoted prices read back as they were, keeping when they were got to the second
+func TestDemote_KeepsPricesReadable(t *testing.T) {
+	clock := newFakeClock()
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 6, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	clock.Advance(1500 * time.Millisecond)
+	cache.Set("p1", 5)
+	cache.Set("p2", 7)
+	clock.Advance(10 * time.Second)
+	cache.Set("p3", 9)
+	assertInt(t, 2, cache.demote(10*time.Second), "wrong number of demoted items")
+	assertFloatsInOrder(t, []float64{5, 7, 9}, getPricesWithNoErr(t, cache, "p1", "p2", "p3"), "wrong prices returned")
+	assertInt(t, 0, mockService.getNumCalls(), "wrong number of service calls")
+	// the time they were got is rounded down to the second
+	age, _ := cache.Age("p1")
+	assertInt(t, int(10500*time.Millisecond), int(age), "wrong age of a demoted price")
+	assertInt(t, 3, len(cache.DumpState()), "wrong number of entries")
+	// a demoted price is stale up to a second earlier than it would have been, and is promoted back when refreshed
+	clock.Advance(49500 * time.Millisecond)
+	if _, fresh, _ := cache.Peek("p1"); fresh {
+		t.Error("expected the demoted price to be stale")
+	}
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "wrong refreshed price returned")
+	age, _ = cache.Age("p1")
+	assertInt(t, 0, int(age), "wrong age of a promoted price")
+	assertInt(t, 0, cache.demote(time.Minute), "promoted price demoted again")
+	// demoted prices are swept as the others once expired
+	clock.Advance(time.Minute)
+	assertInt(t, 3, len(cache.sweep()), "wrong number of swept prices")
+}
+
+// Check that the items read since the previous pass are kept, even when they were not written for the window
+func TestDemote_KeepsTheItemsRead(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(0), WithClock(clock), WithDemotion(time.Hour))
+	cache.Set("hot", 5)
+	cache.Set("cold", 7)
+	clock.Advance(2 * time.Hour)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "hot"), "wrong price returned")
+	assertInt(t, 1, cache.demote(time.Hour), "wrong number of demoted items")
+	if _, ok := cache.shardFor("hot").expirationByItem["hot"]; !ok {
+		t.Error("the item read was demoted")
+	}
+	// a pass without reads demotes it
+	assertInt(t, 1, cache.demote(time.Hour), "wrong number of demoted items")
+	assertFloatsInOrder(t, []float64{5, 7}, getPricesWithNoErr(t, cache, "hot", "cold"), "wrong prices returned")
+}
+
+// Check that times too far from the epoch of the compact storage are not demoted, so they don't wrap around
+func TestDemote_KeepsTimesOutOfRange(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock),
+		WithInitialPricesAt(map[string]float64{"p1": 5, "p2": 7}, map[string]time.Time{"p1": {}}))
+	clock.Advance(time.Hour)
+	assertInt(t, 1, cache.demote(time.Minute), "wrong number of demoted items")
+	if _, fresh, ok := cache.Peek("p1"); !ok || fresh {
+		t.Errorf("expected the price got long ago to be cached and stale, got ok %v and fresh %v", ok, fresh)
+	}
+	if age, _ := cache.Age("p1"); age < 0 {
+		t.Errorf("expected a positive age, got %v", age)
+	}
+}
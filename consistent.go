@@ -0,0 +1,40 @@
+package main
+
+// Answer the groups whose price is fresh as of now, reading every shard at once so the batch sees the cache
+// as it was when it started, whatever is invalidated or set while the rest of it is got
+// It returns the groups left to get through the live path
+func (c *TransparentCache[V]) answerFromSnapshot(itemCodes []string, groups itemGroups,
+	deliver func(index int, price V, err error)) itemGroups {
+	if c.isClosed() {
+		return groups
+	}
+	type answer struct {
+		first int
+		price V
+	}
+	var answers []answer
+	left := itemGroups{firsts: make([]int, 0, len(groups.firsts)), next: groups.next}
+	now := c.clock.Now()
+	c.rlockAll()
+	for _, first := range groups.firsts {
+		itemCode := itemCodes[first]
+		s := c.shardFor(itemCode)
+		price, ok := s.prices[itemCode]
+		cachedAt, _ := s.cachedAt(itemCode)
+		if !ok || !isFresh(cachedAt, c.maxAgeFor(s, itemCode), now) {
+			left.firsts = append(left.firsts, first)
+			continue
+		}
+		c.touch(s, itemCode)
+		c.noteRead(s, itemCode)
+		answers = append(answers, answer{first: first, price: price})
+	}
+	c.runlockAll()
+	for _, a := range answers {
+		itemCode := itemCodes[a.first]
+		c.stats.hits.Add(1)
+		c.emit(itemCode, EventHit)
+		groups.each(a.first, func(index int) { deliver(index, a.price, nil) })
+	}
+	return left
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Check that an item invalidated while a batch runs is got again live, but not with snapshot batches
+func TestWithSnapshotBatches_IgnoresInvalidationsMidBatch(t *testing.T) {
+	for _, snapshot := range []bool{false, true} {
+		mockService := &mockPriceService{
+			mockResults: map[string]mockResult{
+				"p2": {price: 7, err: nil},
+			},
+			callDelay: 50 * time.Millisecond,
+		}
+		options := []Option{WithMaxAge(time.Minute), WithMaxConcurrency(1)}
+		if snapshot {
+			options = append(options, WithSnapshotBatches())
+		}
+		cache := NewTransparentCache(mockService, options...)
+		cache.Set("p1", 5)
+		result := make(chan []float64, 1)
+		go func() { result <- getPricesWithNoErr(t, cache, "p2", "p1") }()
+		// p1 comes after the slow p2 on the single worker, it is invalidated while p2 is got
+		waitFor(t, func() bool { return mockService.getNumCalls() == 1 }, "the service was not called")
+		mockService.setResult("p1", mockResult{price: 6})
+		cache.Invalidate("p1")
+		expected := []float64{7, 6}
+		if snapshot {
+			expected = []float64{7, 5}
+		}
+		assertFloatsInOrder(t, expected, <-result, "wrong prices returned")
+	}
+}
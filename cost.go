@@ -0,0 +1,29 @@
+package main
+
+// CostEvictionWindow is how many of the least recently used prices are weighed by cost when evicting, see WithCostFunc
+const CostEvictionWindow = 16
+
+// Pick the item to evict next, the least recently used one, or with a cost function the cheapest of the least
+// recently used ones, the oldest winning ties, no lock must be held
+func (c *TransparentCache[V]) evictionCandidate() (string, bool) {
+	if c.costOf == nil {
+		return c.recency.oldest()
+	}
+	var candidate string
+	found := false
+	cheapest := 0
+	for _, itemCode := range c.recency.oldestN(CostEvictionWindow) {
+		s := c.shardFor(itemCode)
+		s.RLock()
+		cost, ok := s.costByItem[itemCode]
+		s.RUnlock()
+		if !ok {
+			// no longer cached, so it costs nothing to drop from the recency list
+			return itemCode, true
+		}
+		if !found || cost < cheapest {
+			candidate, cheapest, found = itemCode, cost, true
+		}
+	}
+	return candidate, found
+}
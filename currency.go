@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// CurrencyPriceService is an optional variant of PriceService that can get the price for an item in any currency
+// GetPriceForCurrency is only reachable through it
+type CurrencyPriceService interface {
+	GetPriceForCurrency(itemCode, currency string) (float64, error)
+}
+
+// ErrNoCurrencies is returned by GetPriceForCurrency when the service is not a CurrencyPriceService
+var ErrNoCurrencies = errors.New("service has no currencies")
+
+// Separates the item code from the currency in the cache keys, it is not expected in item codes
+const currencySeparator = "\x00"
+
+// errNoCurrencyVersion stops revalidating prices in a currency, versions are only known for the default one
+var errNoCurrencyVersion = errors.New("no version for prices in a currency")
+
+// Get the key the price of the item in the currency is cached under
+// Prices in the default currency are cached under the item code alone, so they are shared with GetPriceFor
+func (c *PriceCache) currencyKey(itemCode, currency string) string {
+	if currency == c.defaultCurrency {
+		currency = ""
+	}
+	return joinKey(itemCode, currency)
+}
+
+// Get the cache key of the item in the currency, which is empty for the default one
+func joinKey(itemCode, currency string) string {
+	if currency == "" {
+		return itemCode
+	}
+	return itemCode + currencySeparator + currency
+}
+
+// Get the item code and the currency of a cache key, the currency is empty for the default one
+func splitKey(key string) (string, string) {
+	itemCode, currency, _ := strings.Cut(key, currencySeparator)
+	return itemCode, currency
+}
+
+// currencySet holds the currencies prices were cached in besides the default one,
+// so an item is invalidated in every one of them
+type currencySet struct {
+	sync.RWMutex
+	seen map[string]struct{}
+}
+
+// Note a currency prices are cached in
+func (s *currencySet) add(currency string) {
+	s.RLock()
+	_, ok := s.seen[currency]
+	s.RUnlock()
+	if ok || currency == "" {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.seen == nil {
+		s.seen = map[string]struct{}{}
+	}
+	s.seen[currency] = struct{}{}
+}
+
+// Tell whether prices were cached in any other currency than the default one
+func (s *currencySet) any() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.seen) > 0
+}
+
+// Get the cache keys of the item in the default currency and in every currency seen
+func (s *currencySet) keys(itemCode string) []string {
+	s.RLock()
+	defer s.RUnlock()
+	keys := make([]string, 0, len(s.seen)+1)
+	keys = append(keys, itemCode)
+	for currency := range s.seen {
+		keys = append(keys, joinKey(itemCode, currency))
+	}
+	return keys
+}
+
+// GetPriceForCurrency gets the price for the item in the currency as GetPriceFor does
+// Every currency of the item is cached on its own, with its own expiration, but Invalidate and InvalidateMany
+// remove the item in every currency, as InvalidatePrefix does
+// Keys and ForEach only list the items in the default currency, while DumpState and Snapshot tell the currency
+// of every price
+func (c *PriceCache) GetPriceForCurrency(itemCode, currency string) (float64, error) {
+	itemCode = c.key(itemCode)
+	if isEmptyItemCode(itemCode) {
+		return 0, ErrEmptyItemCode
+	}
+	key := c.currencyKey(itemCode, currency)
+	if key != itemCode && c.service().getCurrency == nil {
+		return 0, ErrNoCurrencies
+	}
+	if key != itemCode {
+		c.currencies.add(currency)
+	}
+	// the key is already normalized, with the currency appended
+	price, _, err := c.getPrice(context.Background(), key)
+	return price, err
+}
+
+// Route the keys with a currency through GetPriceForCurrency, and every key when there is a default currency
+// Those keys are got one at a time, without a context or a version
+func (b *backend[V]) inCurrencies(getCurrency func(itemCode, currency string) (V, error), defaultCurrency string) {
+	b.getCurrency = getCurrency
+	inCurrency := func(key string) (string, string, bool) {
+		itemCode, currency, ok := strings.Cut(key, currencySeparator)
+		if !ok && defaultCurrency != "" {
+			return key, defaultCurrency, true
+		}
+		return itemCode, currency, ok
+	}
+	get := b.get
+	b.get = func(key string) (V, error) {
+		if itemCode, currency, ok := inCurrency(key); ok {
+			return getCurrency(itemCode, currency)
+		}
+		return get(key)
+	}
+	if getContext := b.getContext; getContext != nil {
+		b.getContext = func(ctx context.Context, key string) (V, error) {
+			if itemCode, currency, ok := inCurrency(key); ok {
+				return detached(ctx, func() (V, error) { return getCurrency(itemCode, currency) })
+			}
+			return getContext(ctx, key)
+		}
+	}
+	if getVersion := b.getVersion; getVersion != nil {
+		b.getVersion = func(key string) (string, error) {
+			if _, _, ok := inCurrency(key); ok {
+				return "", errNoCurrencyVersion
+			}
+			return getVersion(key)
+		}
+	}
+	if defaultCurrency != "" {
+		b.getBatch = nil
+	} else if getBatch := b.getBatch; getBatch != nil {
+		b.getBatch = func(keys []string) (map[string]V, error) {
+			var plain []string
+			prices := make(map[string]V, len(keys))
+			for _, key := range keys {
+				itemCode, currency, ok := inCurrency(key)
+				if !ok {
+					plain = append(plain, key)
+					continue
+				}
+				price, err := getCurrency(itemCode, currency)
+				if err != nil {
+					return nil, err
+				}
+				prices[key] = price
+			}
+			if len(plain) == 0 {
+				return prices, nil
+			}
+			plainPrices, err := getBatch(plain)
+			if err != nil {
+				return nil, err
+			}
+			for key, price := range plainPrices {
+				prices[key] = price
+			}
+			return prices, nil
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// currencyPriceService answers with the price of the item in the currency, counting the calls for each currency
+type currencyPriceService struct {
+	mu              sync.Mutex
+	prices          map[string]float64 // by currency
+	callsByCurrency map[string]int
+	plainCalls      int
+}
+
+func newCurrencyPriceService(prices map[string]float64) *currencyPriceService {
+	return &currencyPriceService{prices: prices, callsByCurrency: make(map[string]int)}
+}
+
+func (m *currencyPriceService) GetPriceFor(itemCode string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.plainCalls++
+	return m.prices["USD"], nil
+}
+
+func (m *currencyPriceService) GetPriceForCurrency(itemCode, currency string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callsByCurrency[currency]++
+	price, ok := m.prices[currency]
+	if !ok {
+		return 0, errors.New("unknown currency")
+	}
+	return price, nil
+}
+
+func (m *currencyPriceService) getCalls(currency string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.callsByCurrency[currency]
+}
+
+func getCurrencyPriceWithNoErr(t *testing.T, cache *PriceCache, itemCode, currency string) float64 {
+	t.Helper()
+	price, err := cache.GetPriceForCurrency(itemCode, currency)
+	if err != nil {
+		t.Fatalf("unexpected error getting %v in %v: %v", itemCode, currency, err)
+	}
+	return price
+}
+
+func TestGetPriceForCurrency_CachesEveryCurrencyOnItsOwn(t *testing.T) {
+	service := newCurrencyPriceService(map[string]float64{"USD": 5, "EUR": 4})
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute), WithDefaultCurrency("USD"))
+	assertFloat(t, 5, getCurrencyPriceWithNoErr(t, cache, "ABC", "USD"), "wrong price in USD")
+	assertFloat(t, 4, getCurrencyPriceWithNoErr(t, cache, "ABC", "EUR"), "wrong price in EUR")
+	assertFloat(t, 4, getCurrencyPriceWithNoErr(t, cache, "ABC", "EUR"), "wrong cached price in EUR")
+	// GetPriceFor shares the prices of the default currency
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "ABC"), "wrong price in the default currency")
+	assertInt(t, 1, service.getCalls("USD"), "wrong number of calls in USD")
+	assertInt(t, 1, service.getCalls("EUR"), "wrong number of calls in EUR")
+	assertInt(t, 0, service.plainCalls, "the default currency should be asked for explicitly")
+	// Len counts the items as Keys lists them, DumpState has every price
+	assertInt(t, 1, cache.Len(), "wrong number of cached items")
+	assertInt(t, 2, len(cache.DumpState()), "wrong number of cached prices")
+}
+
+func TestGetPriceForCurrency_ExpiresEveryCurrencyOnItsOwn(t *testing.T) {
+	service := newCurrencyPriceService(map[string]float64{"USD": 5, "EUR": 4})
+	clock := newFakeClock()
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute), WithClock(clock))
+	getCurrencyPriceWithNoErr(t, cache, "ABC", "USD")
+	clock.Advance(30 * time.Second)
+	getCurrencyPriceWithNoErr(t, cache, "ABC", "EUR")
+	clock.Advance(40 * time.Second)
+	getCurrencyPriceWithNoErr(t, cache, "ABC", "USD")
+	getCurrencyPriceWithNoErr(t, cache, "ABC", "EUR")
+	assertInt(t, 2, service.getCalls("USD"), "the price in USD should have expired")
+	assertInt(t, 1, service.getCalls("EUR"), "the price in EUR should still be fresh")
+	cache.InvalidateAll()
+	prices, err := cache.GetPricesFor("ABC")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFloats(t, []float64{5}, prices, "without a default currency GetPriceFor uses the one of the service")
+	assertInt(t, 1, service.plainCalls, "wrong number of calls without a currency")
+}
+
+func TestGetPriceForCurrency_FailsWithoutCurrencies(t *testing.T) {
+	mockService := &mockPriceService{mockResults: map[string]mockResult{"ABC": {price: 5}}}
+	cache := NewTransparentCache(mockService, WithDefaultCurrency("USD"))
+	if _, err := cache.GetPriceForCurrency("ABC", "EUR"); !errors.Is(err, ErrNoCurrencies) {
+		t.Errorf("expected ErrNoCurrencies, got %v", err)
+	}
+	assertFloat(t, 5, getCurrencyPriceWithNoErr(t, cache, "ABC", "USD"), "wrong price in the default currency")
+}
+
+// Check that the listings tell the currency of the prices, and that invalidating an item removes every currency
+func TestGetPriceForCurrency_ListsAndInvalidatesEveryCurrency(t *testing.T) {
+	service := newCurrencyPriceService(map[string]float64{"USD": 5, "EUR": 4, "GBP": 3})
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "ABC"), "wrong price in the default currency")
+	assertFloat(t, 4, getCurrencyPriceWithNoErr(t, cache, "ABC", "EUR"), "wrong price in EUR")
+	assertFloat(t, 3, getCurrencyPriceWithNoErr(t, cache, "DEF", "GBP"), "wrong price in GBP")
+	assertStrings(t, []string{"ABC"}, sortedKeys(cache), "wrong cached item codes")
+	assertInt(t, 1, cache.Len(), "wrong number of cached items")
+	visited := 0
+	cache.ForEach(func(itemCode string, price float64, expiresAt time.Time) bool {
+		visited++
+		return true
+	})
+	assertInt(t, 1, visited, "wrong number of items visited")
+	var listed []string
+	for _, entry := range cache.DumpState() {
+		listed = append(listed, entry.ItemCode+"/"+entry.Currency)
+	}
+	assertStrings(t, []string{"ABC/", "ABC/EUR", "DEF/GBP"}, listed, "wrong entries dumped")
+
+	data, err := cache.Snapshot()
+	if err != nil {
+		t.Fatal("error taking snapshot", err)
+	}
+	if strings.Contains(string(data), currencySeparator) {
+		t.Errorf("the currency separator leaked into the snapshot: %q", data)
+	}
+	restored := NewTransparentCache(service, WithMaxAge(time.Minute))
+	if err := restored.Restore(data); err != nil {
+		t.Fatal("error restoring snapshot", err)
+	}
+	assertFloat(t, 3, getCurrencyPriceWithNoErr(t, restored, "DEF", "GBP"), "wrong restored price in GBP")
+	assertInt(t, 1, service.getCalls("GBP"), "restored price got again")
+	if !restored.Invalidate("DEF") {
+		t.Error("expected the restored price in GBP to be invalidated")
+	}
+
+	if !cache.Invalidate("ABC") {
+		t.Error("expected ABC to be invalidated")
+	}
+	assertInt(t, 1, len(cache.DumpState()), "wrong number of cached prices")
+	assertInt(t, 1, cache.InvalidateMany("DEF"), "wrong number of invalidated prices")
+	assertInt(t, 0, len(cache.DumpState()), "wrong number of cached prices")
+}
+
+// Check that tagging and invalidating a tag cover every currency of the items
+func TestInvalidateTag_RemovesEveryCurrency(t *testing.T) {
+	service := newCurrencyPriceService(map[string]float64{"USD": 5, "EUR": 4, "GBP": 3})
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute))
+	getPriceWithNoErr(t, cache, "tv")
+	getCurrencyPriceWithNoErr(t, cache, "tv", "EUR")
+	getPriceWithNoErr(t, cache, "phone")
+	cache.SetTag("tv", "electronics")
+	// cached in another currency after being tagged
+	getCurrencyPriceWithNoErr(t, cache, "tv", "GBP")
+	getCurrencyPriceWithNoErr(t, cache, "phone", "EUR")
+	cache.SetTag("phone", "electronics")
+	getPriceWithNoErr(t, cache, "book")
+	getCurrencyPriceWithNoErr(t, cache, "book", "EUR")
+	assertInt(t, 5, cache.InvalidateTag("electronics"), "wrong number of invalidated prices")
+	var listed []string
+	for _, entry := range cache.DumpState() {
+		listed = append(listed, entry.ItemCode+"/"+entry.Currency)
+	}
+	assertStrings(t, []string{"book/", "book/EUR"}, listed, "wrong prices left")
+}
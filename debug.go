@@ -0,0 +1,58 @@
+package main
+
+import "fmt"
+
+// Check the internal invariants of the cache when WithDebugInvariants is set, panicking on the first violation
+// Every shard is read locked meanwhile, so no lock must be held
+func (c *TransparentCache[V]) checkInvariants() {
+	if !c.debugInvariants {
+		return
+	}
+	c.rlockAll()
+	defer c.runlockAll()
+	count := 0
+	var bytes int64
+	for i, s := range c.shards {
+		for itemCode, price := range s.prices {
+			if c.shardFor(itemCode) != s {
+				panic(fmt.Sprintf("cache invariant: [%v] is in shard %v, it doesn't hash to", itemCode, i))
+			}
+			_, stamped := s.expirationByItem[itemCode]
+			_, demoted := s.compactAt[itemCode]
+			if stamped == demoted {
+				panic(fmt.Sprintf("cache invariant: [%v] must have exactly one cached time, full %v, compact %v",
+					itemCode, stamped, demoted))
+			}
+			if c.maxBytes > 0 {
+				bytes += c.sizeOf(itemCode, price)
+			}
+		}
+		for itemCode := range s.expirationByItem {
+			if _, ok := s.prices[itemCode]; !ok {
+				panic(fmt.Sprintf("cache invariant: [%v] has a cached time but no price", itemCode))
+			}
+		}
+		for itemCode := range s.compactAt {
+			if _, ok := s.prices[itemCode]; !ok {
+				panic(fmt.Sprintf("cache invariant: [%v] has a compact cached time but no price", itemCode))
+			}
+		}
+		for itemCode := range s.rawByItem {
+			if _, ok := s.prices[itemCode]; !ok {
+				panic(fmt.Sprintf("cache invariant: [%v] has a raw price but no price", itemCode))
+			}
+		}
+		for itemCode := range s.tagByItem {
+			if _, ok := s.prices[itemCode]; !ok {
+				panic(fmt.Sprintf("cache invariant: [%v] has a tag but no price", itemCode))
+			}
+		}
+		count += len(s.prices)
+	}
+	if entries := c.entries.Load(); entries != int64(count) {
+		panic(fmt.Sprintf("cache invariant: %v entries counted, %v cached", entries, count))
+	}
+	if total := c.bytes.Load(); c.maxBytes > 0 && total != bytes {
+		panic(fmt.Sprintf("cache invariant: %v bytes counted, %v estimated", total, bytes))
+	}
+}
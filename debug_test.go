@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newInvariantCache(clock *fakeClock) *PriceCache {
+	service := priceFunc(func(itemCode string) (float64, error) {
+		if strings.HasSuffix(itemCode, "9") {
+			return 0, fmt.Errorf("no price for %v", itemCode)
+		}
+		return float64(len(itemCode)), nil
+	})
+	return NewTransparentCache(service, WithClock(clock), WithMaxAge(time.Minute), WithShards(4),
+		WithMaxEntries(20), WithMaxBytes(64*16), WithDebugInvariants())
+}
+
+// Run the operation encoded by op against the cache
+func applyOperation(cache *PriceCache, clock *fakeClock, op byte, itemCode string) {
+	switch op % 10 {
+	case 0, 1, 2:
+		cache.GetPriceFor(itemCode)
+	case 3:
+		cache.GetPricesFor(itemCode, itemCode+"a", itemCode+"b")
+	case 4:
+		cache.Set(itemCode, float64(op))
+	case 5:
+		cache.SetWithTTL(itemCode, float64(op), time.Duration(op)*time.Second)
+	case 6:
+		cache.Invalidate(itemCode)
+	case 7:
+		cache.InvalidatePrefix(itemCode[:1])
+	case 8:
+		cache.Pin(itemCode)
+		cache.SetTag(itemCode, itemCode[:2])
+		cache.Unpin(itemCode)
+		cache.InvalidateTag(itemCode[:2])
+	case 9:
+		clock.Advance(time.Duration(op) * time.Second)
+		cache.demote(30 * time.Second)
+		cache.sweep()
+	}
+}
+
+func TestWithDebugInvariants_HoldUnderConcurrentLoad(t *testing.T) {
+	clock := newFakeClock()
+	cache := newInvariantCache(clock)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				op := byte(worker*31 + i*7)
+				applyOperation(cache, clock, op, fmt.Sprintf("p%v", (worker+i)%40))
+			}
+		}(worker)
+	}
+	wg.Wait()
+	cache.InvalidateAll()
+	assertInt(t, 0, cache.Len(), "every price should have been invalidated")
+}
+
+func TestWithDebugInvariants_PanicsOnViolation(t *testing.T) {
+	cache := newInvariantCache(newFakeClock())
+	cache.Set("p1", 1)
+	s := cache.shardFor("p1")
+	s.Lock()
+	delete(s.prices, "p1")
+	s.Unlock()
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), "cache invariant") {
+			t.Errorf("expected an invariant violation, got %v", r)
+		}
+	}()
+	cache.Invalidate("p2")
+}
+
+func FuzzCacheOperations(f *testing.F) {
+	f.Add([]byte{0, 4, 6, 9, 3, 7})
+	f.Add([]byte{5, 5, 15, 29, 1, 8, 18})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		clock := newFakeClock()
+		cache := newInvariantCache(clock)
+		for i, op := range ops {
+			applyOperation(cache, clock, op, fmt.Sprintf("p%v", (i*int(op))%30))
+		}
+	})
+}
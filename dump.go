@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// EntryInfo is the state of a cached price, as told by DumpState
+type EntryInfo[V any] struct {
+	ItemCode  string
+	Currency  string // empty for prices in the default currency
+	Price     V
+	CachedAt  time.Time
+	ExpiresAt time.Time // zero for prices which never expire
+	Fresh     bool
+}
+
+// DumpState returns the state of every cached price sorted by item code and currency, for logging or an admin endpoint
+// Every shard is locked while taking it, so it is a consistent view of the cache, and the service is never called
+func (c *TransparentCache[V]) DumpState() []EntryInfo[V] {
+	now := c.clock.Now()
+	c.rlockAll()
+	entries := make([]EntryInfo[V], 0, c.entries.Load())
+	for _, s := range c.shards {
+		for itemCode, price := range s.prices {
+			cachedAt, _ := s.cachedAt(itemCode)
+			maxAge := c.maxAgeFor(s, itemCode)
+			code, currency := splitKey(itemCode)
+			entry := EntryInfo[V]{ItemCode: code, Currency: currency, Price: price, CachedAt: cachedAt,
+				Fresh: isFresh(cachedAt, maxAge, now)}
+			if maxAge > 0 {
+				entry.ExpiresAt = cachedAt.Add(maxAge)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	c.runlockAll()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ItemCode != entries[j].ItemCode {
+			return entries[i].ItemCode < entries[j].ItemCode
+		}
+		return entries[i].Currency < entries[j].Currency
+	})
+	return entries
+}
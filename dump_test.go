@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Check that the dump tells the state of every cached price without calling the service
+func TestDumpState_ListsEntries(t *testing.T) {
+	clock := newFakeClock()
+	mockService := &mockPriceService{}
+	cache := NewTransparentCache(mockService, WithClock(clock), WithMaxAge(time.Minute))
+	start := clock.Now()
+	cache.Set("p2", 7)
+	clock.Advance(30 * time.Second)
+	cache.Set("p1", 5)
+	cache.SetWithTTL("p3", 9, 10*time.Second)
+	clock.Advance(40 * time.Second)
+
+	entries := cache.DumpState()
+	assertInt(t, 3, len(entries), "wrong number of entries")
+	expected := []EntryInfo[float64]{
+		{ItemCode: "p1", Price: 5, CachedAt: start.Add(30 * time.Second), ExpiresAt: start.Add(90 * time.Second), Fresh: true},
+		{ItemCode: "p2", Price: 7, CachedAt: start, ExpiresAt: start.Add(time.Minute), Fresh: false},
+		{ItemCode: "p3", Price: 9, CachedAt: start.Add(30 * time.Second), ExpiresAt: start.Add(40 * time.Second), Fresh: false},
+	}
+	for i, entry := range entries {
+		if entry != expected[i] {
+			t.Errorf("wrong entry %v, expected %+v, got %+v", i, expected[i], entry)
+		}
+	}
+	assertInt(t, 0, mockService.getNumCalls(), "wrong number of service calls")
+}
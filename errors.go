@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrClosed is returned when getting prices from a cache after closing it
+var ErrClosed = errors.New("cache is closed")
+
+// ErrCircuitOpen is returned without calling the service while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrEmptyItemCode is returned for item codes which are empty or only white space, without calling the service
+var ErrEmptyItemCode = errors.New("empty item code")
+
+// Tell whether the item code is empty or only white space
+func isEmptyItemCode(itemCode string) bool {
+	return strings.TrimSpace(itemCode) == ""
+}
+
+// ErrServiceFailure wraps the errors got from the service, which are kept in the chain for errors.Is and errors.As
+var ErrServiceFailure = errors.New("getting price from service")
+
+// ErrNoHealthProbe is returned by HealthCheck when no probe item was configured with WithHealthProbeItem
+var ErrNoHealthProbe = errors.New("no health probe item")
+
+// ErrItemNotFound is in the chain of the errors for items the service has no price for,
+// as told by WithNotFoundDetector or by a batch service leaving the item out
+var ErrItemNotFound = errors.New("item not found")
+
+// ErrServiceUnavailable is in the chain of the other service errors when there is a not found detector
+var ErrServiceUnavailable = errors.New("service unavailable")
+
+// Wrap the service error, telling apart not found items from an unavailable service when there is a detector
+func (c *TransparentCache[V]) wrapServiceError(err error) error {
+	switch {
+	case c.isNotFound == nil:
+		return fmt.Errorf("%w : %w", ErrServiceFailure, err)
+	case c.isNotFound(err):
+		return fmt.Errorf("%w : %w : %w", ErrServiceFailure, ErrItemNotFound, err)
+	}
+	return fmt.Errorf("%w : %w : %w", ErrServiceFailure, ErrServiceUnavailable, err)
+}
+
+// ErrServiceTimeout is in the chain of the errors for service calls taking longer than WithServiceTimeout
+var ErrServiceTimeout = errors.New("service call timed out")
+
+// ErrServicePanic is in the chain of the errors for service calls which panicked, along with what they panicked with
+var ErrServicePanic = errors.New("service panicked")
+
+// Make the call to the service, turning a panic into an ErrServicePanic error so a misbehaving service
+// can't take down the goroutines of the cache, nor leave the waiters of a batch hanging
+func guarded[T any](call func() (T, error)) (result T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero T
+			result = zero
+			if panicErr, ok := r.(error); ok {
+				err = fmt.Errorf("%w : %w", ErrServicePanic, panicErr)
+			} else {
+				err = fmt.Errorf("%w : %v", ErrServicePanic, r)
+			}
+		}
+	}()
+	return call()
+}
+
+// ItemError is the error got for one of the item codes of a batch, along with its position in the batch
+type ItemError struct {
+	Index    int
+	ItemCode string
+	Err      error
+}
+
+// MultiError is returned for a batch with failing items, with the error of every failing item code
+// sorted by its position in the batch, so the same failures always give the same message
+type MultiError struct {
+	errs []ItemError
+}
+
+// Build the error of a batch from the errors of its items, in any order
+func newMultiError(errs []ItemError) *MultiError {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	return &MultiError{errs: errs}
+}
+
+// Errors returns the error of every failing item code, in the order they were asked for
+func (e *MultiError) Errors() []ItemError {
+	return append([]ItemError(nil), e.errs...)
+}
+
+// Error lists the failing item codes with their errors, in the order they were asked for
+func (e *MultiError) Error() string {
+	parts := make([]string, len(e.errs))
+	for i, itemErr := range e.errs {
+		parts[i] = fmt.Sprintf("[%v] %v", itemErr.ItemCode, itemErr.Err)
+	}
+	return fmt.Sprintf("%d items failed : %v", len(e.errs), strings.Join(parts, " ; "))
+}
+
+// Unwrap gives the errors of the items, so errors.Is and errors.As look into every one of them
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, len(e.errs))
+	for i, itemErr := range e.errs {
+		errs[i] = itemErr.Err
+	}
+	return errs
+}
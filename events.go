@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// EventType is what happened to a cached item
+type EventType int
+
+const (
+	EventHit         EventType = iota // a fresh cached price was returned
+	EventMiss                         // the price had to be got from the service
+	EventEvict                        // a cached price was removed, by eviction or explicitly
+	EventRefresh                      // a cached price was renewed in the background
+	EventStale                        // an expired price was returned while being revalidated
+	EventServiceCall                  // the service was called, Duration tells how long it took
+	EventFallback                     // the price was got from the fallback service as the actual one failed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventEvict:
+		return "evict"
+	case EventRefresh:
+		return "refresh"
+	case EventStale:
+		return "stale"
+	case EventServiceCall:
+		return "call"
+	case EventFallback:
+		return "fallback"
+	}
+	return "unknown"
+}
+
+// CacheEvent is passed to the event hook every time something happens to a cached item
+// For service calls Duration and Err are set too, and ItemCode is empty when it was a batch call
+type CacheEvent struct {
+	ItemCode string
+	Type     EventType
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// Call the event hook, if there is one, it must never be called while holding the lock
+// so the hook can call back into the cache
+func (c *TransparentCache[V]) emit(itemCode string, eventType EventType) {
+	if c.logger != nil {
+		c.logEvent(itemCode, eventType)
+	}
+	if c.eventHook == nil {
+		return
+	}
+	c.eventHook(CacheEvent{ItemCode: itemCode, Type: eventType, Time: c.clock.Now()})
+}
+
+// Emit a service call event for a call started at start
+// The duration of the call is recorded for LatencyStats too
+func (c *TransparentCache[V]) emitServiceCall(itemCode string, start time.Time, err error) {
+	now := c.clock.Now()
+	c.latency.record(now.Sub(start))
+	if c.logger != nil && err != nil {
+		c.logger.Warnf("service error for [%v] : %v", itemCode, err)
+	}
+	if c.eventHook == nil {
+		return
+	}
+	c.eventHook(CacheEvent{ItemCode: itemCode, Type: EventServiceCall, Time: now, Duration: now.Sub(start), Err: err})
+}
+
+// Emit an evict event for each item code
+func (c *TransparentCache[V]) emitEvictions(itemCodes []string) {
+	for _, itemCode := range itemCodes {
+		c.emit(itemCode, EventEvict)
+	}
+}
+
+// Tell the error callback, if there is one, about a failed service call for the items, unless the caller gave up
+// It must never be called while holding the lock
+func (c *TransparentCache[V]) reportError(ctx context.Context, itemCodes []string, err error) {
+	if c.onError == nil || err == nil || ctx.Err() != nil {
+		return
+	}
+	for _, itemCode := range itemCodes {
+		c.onError(itemCode, err)
+	}
+}
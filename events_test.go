@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder keeps the events given to the hook
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) record(ev CacheEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%v:%v", ev.Type, ev.ItemCode))
+}
+
+func (r *eventRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func assertStrings(t *testing.T, expected []string, actual []string, msg string) {
+	if fmt.Sprint(expected) != fmt.Sprint(actual) {
+		t.Error(msg, fmt.Sprintf("expected : %v, got : %v", expected, actual))
+	}
+}
+
+// Check that the hook gets the events of a scripted sequence, and can use the cache while doing so
+func TestWithEventHook_ReportsEvents(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	recorder := &eventRecorder{}
+	clock := newFakeClock()
+	var cache *PriceCache
+	cache = NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithMaxEntries(2),
+		WithStaleWhileRevalidate(time.Minute), WithEventHook(func(ev CacheEvent) {
+			cache.Peek(ev.ItemCode)
+			recorder.record(ev)
+		}))
+	defer cache.Close()
+	getPriceWithNoErr(t, cache, "p1")
+	getPriceWithNoErr(t, cache, "p1")
+	getPriceWithNoErr(t, cache, "p2")
+	getPriceWithNoErr(t, cache, "p3")
+	cache.Invalidate("p3")
+	assertStrings(t, []string{"miss:p1", "call:p1", "hit:p1", "miss:p2", "call:p2", "miss:p3", "call:p3", "evict:p1", "evict:p3"},
+		recorder.get(), "wrong events")
+	clock.Advance(time.Minute)
+	getPriceWithNoErr(t, cache, "p2")
+	waitFor(t, func() bool { return len(recorder.get()) == 12 }, "missing refresh event")
+	assertStrings(t, []string{"stale:p2", "call:p2", "refresh:p2"}, recorder.get()[9:], "wrong events")
+}
+
+// Check that the error callback is told about failed calls, for a waiting caller and for a background refresh
+func TestWithOnError_ReportsForegroundAndBackgroundFailures(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+		},
+	}
+	var mu sync.Mutex
+	var reported []string
+	onError := func(itemCode string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, fmt.Sprintf("%v:%v", itemCode, err))
+	}
+	getReported := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), reported...)
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStaleWhileRevalidate(time.Minute),
+		WithOnError(onError))
+	if _, err := cache.GetPriceFor("p2"); err == nil {
+		t.Error("expected error, got nil")
+	}
+	assertStrings(t, []string{"p2:p2 error"}, getReported(), "wrong errors reported")
+	// the stale price is returned right away, the failure of its refresh is only seen by the callback
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	mockService.setResult("p1", mockResult{err: fmt.Errorf("p1 error")})
+	clock.Advance(time.Minute)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong stale price returned")
+	waitFor(t, func() bool { return len(getReported()) == 2 }, "background failure not reported")
+	assertStrings(t, []string{"p2:p2 error", "p1:p1 error"}, getReported(), "wrong errors reported")
+}
@@ -0,0 +1,34 @@
+package main
+
+import "context"
+
+// Resolve the capabilities of the fallback service, which is nil unless it is of the type of the cache values
+func fallbackBackend[V any](service any, defaultCurrency string) *backend[V] {
+	switch s := service.(type) {
+	case Service[V]:
+		return newBackend(s)
+	case PriceService:
+		// only caches of float64 prices can fail over to a price service
+		b, _ := any(newPriceBackend(s, defaultCurrency)).(*backend[V])
+		return b
+	}
+	return nil
+}
+
+// Get the price from the service, failing over to the fallback service when it fails and there is one
+// The error of the actual service is returned when the fallback fails too
+// The source tells which of the services the price came from
+func (c *TransparentCache[V]) fetchWithFallback(ctx context.Context, itemCode string) (V, Source, error) {
+	price, err := c.fetchWithRetry(ctx, itemCode)
+	if err == nil || c.fallback == nil || ctx.Err() != nil {
+		return price, SourceMiss, err
+	}
+	callCtx, cancel := c.withItemTimeout(ctx, itemCode)
+	defer cancel()
+	fallbackPrice, fallbackErr := c.callService(callCtx, c.fallback, itemCode)
+	if fallbackErr != nil {
+		return price, SourceMiss, err
+	}
+	c.emit(itemCode, EventFallback)
+	return fallbackPrice, SourceFallback, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Check that a failing primary service fails over to the fallback, whose price is cached as any other
+func TestWithFallbackService_FailsOver(t *testing.T) {
+	primary := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 0, err: fmt.Errorf("primary down")},
+			"p2": {price: 0, err: fmt.Errorf("primary down")},
+		},
+	}
+	secondary := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("secondary down")},
+		},
+	}
+	recorder := &eventRecorder{}
+	cache := NewTransparentCache(primary, WithMaxAge(time.Minute), WithFallbackService(secondary), WithEventHook(recorder.record))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong cached price returned")
+	assertInt(t, 1, primary.getNumCalls(), "wrong number of primary service calls")
+	assertInt(t, 1, secondary.getNumCalls(), "wrong number of fallback service calls")
+	assertStrings(t, []string{"miss:p1", "call:p1", "fallback:p1", "hit:p1"}, recorder.get(), "wrong events")
+	// the error of the primary is returned when both fail
+	if _, err := cache.GetPriceFor("p2"); err == nil || err.Error() != "getting price from service : primary down" {
+		t.Errorf("expected the primary error, got %v", err)
+	}
+}
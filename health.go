@@ -0,0 +1,15 @@
+package main
+
+import "context"
+
+// HealthCheck tells whether the service is reachable, by getting the price for the probe item from it
+// The probe never goes through the cache, its result is not cached nor counted in the stats
+func (c *TransparentCache[V]) HealthCheck(ctx context.Context) error {
+	if c.healthProbe == "" {
+		return ErrNoHealthProbe
+	}
+	if _, err := c.callService(ctx, c.service(), c.healthProbe); err != nil {
+		return c.wrapServiceError(err)
+	}
+	return nil
+}
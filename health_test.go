@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Check that the health check reports the service error for the probe item, without caching anything
+func TestHealthCheck_ProbesService(t *testing.T) {
+	errDown := errors.New("service down")
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"probe": {price: 1, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithHealthProbeItem("probe"))
+	if err := cache.HealthCheck(context.Background()); err != nil {
+		t.Error("expected a healthy service, got", err)
+	}
+	mockService.setResult("probe", mockResult{err: errDown})
+	if err := cache.HealthCheck(context.Background()); !errors.Is(err, errDown) {
+		t.Errorf("expected the service error, got %v", err)
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	assertInt(t, 0, cache.Len(), "probe result cached")
+	assertInt(t, 0, int(cache.Stats().Misses), "probe counted as a miss")
+}
+
+// Check that the health check needs a probe item
+func TestHealthCheck_NeedsProbeItem(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{})
+	if err := cache.HealthCheck(context.Background()); !errors.Is(err, ErrNoHealthProbe) {
+		t.Errorf("expected ErrNoHealthProbe, got %v", err)
+	}
+}
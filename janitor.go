@@ -0,0 +1,49 @@
+package main
+
+import "time"
+
+// Sweep the expired entries every interval until the cache is closed
+func (c *TransparentCache[V]) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.emitEvictions(c.sweep())
+		case <-c.lifetime.Done():
+			return
+		}
+	}
+}
+
+// Remove the prices past their max age and stale grace, or the max stale on error when longer, and the errors past the negative TTL
+// Prices which never expire are kept, only the least recently used eviction removes them, and pinned prices too
+// Shards are locked one at a time, so the other shards can be used while one is swept
+// It returns the item codes of the removed prices
+func (c *TransparentCache[V]) sweep() []string {
+	var removed []string
+	for _, s := range c.shards {
+		s.Lock()
+		now := c.clock.Now()
+		for itemCode := range s.prices {
+			if _, pinned := s.pinned[itemCode]; pinned {
+				continue
+			}
+			cachedAt, _ := s.cachedAt(itemCode)
+			if maxAge := c.maxAgeFor(s, itemCode); maxAge <= 0 || isFresh(cachedAt, max(maxAge+c.staleGrace, c.maxStaleOnError), now) {
+				continue
+			}
+			c.removeEntry(s, itemCode)
+			c.stats.evictions.Add(1)
+			removed = append(removed, itemCode)
+		}
+		for itemCode, f := range s.failures {
+			if !f.cachedAt.Add(c.negativeTTL).After(now) {
+				delete(s.failures, itemCode)
+			}
+		}
+		s.Unlock()
+	}
+	c.checkInvariants()
+	return removed
+}
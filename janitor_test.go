@@ -0,0 +1,45 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Check that the janitor removes the expired prices in the background, keeping the fresh ones
+func TestWithJanitor_RemovesExpiredPrices(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	clock := newFakeClock()
+	recorder := &eventRecorder{}
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock),
+		WithJanitor(5*time.Millisecond), WithEventHook(recorder.record))
+	cache.Set("p1", 5)
+	cache.Set("p2", 7)
+	clock.Advance(30 * time.Second)
+	cache.Set("p3", 9)
+	assertInt(t, 3, cache.Len(), "wrong number of cached prices")
+	clock.Advance(30 * time.Second)
+	waitFor(t, func() bool { return cache.Len() == 1 }, "expired prices not removed")
+	assertStrings(t, []string{"p3"}, cache.Keys(), "wrong cached item codes")
+	assertInt(t, 2, int(cache.Stats().Evictions), "wrong number of evictions")
+	assertInt(t, 2, len(recorder.get()), "wrong number of events")
+	if err := cache.Close(); err != nil {
+		t.Error("error closing the cache", err)
+	}
+	waitForGoroutines(t, goroutines)
+}
+
+// Check that prices within the stale grace are kept by the janitor
+func TestWithJanitor_KeepsStalePricesWithinGrace(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock),
+		WithStaleWhileRevalidate(time.Minute))
+	defer cache.Close()
+	cache.Set("p1", 5)
+	clock.Advance(90 * time.Second)
+	cache.sweep()
+	assertInt(t, 1, cache.Len(), "stale price removed")
+	clock.Advance(30 * time.Second)
+	cache.sweep()
+	assertInt(t, 0, cache.Len(), "expired price not removed")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// latencySubBuckets is how many buckets each power of two of nanoseconds is split into, about 9% wide each
+const latencySubBuckets = 8
+
+// latencyBuckets covers service calls up to about 18 minutes, longer ones fall in the last bucket
+const latencyBuckets = 40*latencySubBuckets + 1
+
+// LatencyStats summarizes how long the calls to the actual service took
+// The percentiles are estimated from logarithmic buckets, so they are within about 9% of the exact ones
+type LatencyStats struct {
+	Count uint64
+	Min   time.Duration
+	Max   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// latencyRecorder records durations with atomic counters only, so recording doesn't contend under load
+type latencyRecorder struct {
+	count   atomic.Uint64
+	sum     atomic.Int64
+	min     atomic.Int64 // 0 until something is recorded
+	max     atomic.Int64
+	buckets [latencyBuckets]atomic.Uint64
+}
+
+// Get the bucket of the duration
+func latencyBucket(d time.Duration) int {
+	if d <= 1 {
+		return 0
+	}
+	return min(int(math.Log2(float64(d))*latencySubBuckets)+1, latencyBuckets-1)
+}
+
+// Get the longest duration falling in the bucket
+func latencyBucketBound(bucket int) time.Duration {
+	return time.Duration(math.Exp2(float64(bucket) / latencySubBuckets))
+}
+
+func (r *latencyRecorder) record(d time.Duration) {
+	if d < 1 {
+		// every recorded call took some time, so 0 can tell nothing was recorded yet
+		d = 1
+	}
+	r.count.Add(1)
+	r.sum.Add(int64(d))
+	r.buckets[latencyBucket(d)].Add(1)
+	for current := r.min.Load(); current == 0 || int64(d) < current; current = r.min.Load() {
+		if r.min.CompareAndSwap(current, int64(d)) {
+			break
+		}
+	}
+	for current := r.max.Load(); int64(d) > current; current = r.max.Load() {
+		if r.max.CompareAndSwap(current, int64(d)) {
+			break
+		}
+	}
+}
+
+// Summarize the recorded durations, calls recorded meanwhile might be partly counted
+func (r *latencyRecorder) stats() LatencyStats {
+	var counts [latencyBuckets]uint64
+	var count uint64
+	for i := range r.buckets {
+		counts[i] = r.buckets[i].Load()
+		count += counts[i]
+	}
+	if count == 0 {
+		return LatencyStats{}
+	}
+	stats := LatencyStats{
+		Count: count,
+		Min:   time.Duration(r.min.Load()),
+		Max:   time.Duration(r.max.Load()),
+		Mean:  time.Duration(r.sum.Load() / int64(r.count.Load())),
+	}
+	quantile := func(q float64) time.Duration {
+		target := uint64(math.Ceil(q * float64(count)))
+		var seen uint64
+		for i, n := range counts {
+			seen += n
+			if seen >= target {
+				return min(max(latencyBucketBound(i), stats.Min), stats.Max)
+			}
+		}
+		return stats.Max
+	}
+	stats.P50, stats.P95, stats.P99 = quantile(0.5), quantile(0.95), quantile(0.99)
+	return stats
+}
+
+// LatencyStats returns how long the calls to the actual service took, batch calls included
+func (c *TransparentCache[V]) LatencyStats() LatencyStats {
+	return c.latency.stats()
+}
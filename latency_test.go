@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// slowPriceService takes the given time on the fake clock for each item
+type slowPriceService struct {
+	clock     *fakeClock
+	durations map[string]time.Duration
+}
+
+func (s *slowPriceService) GetPriceFor(itemCode string) (float64, error) {
+	s.clock.Advance(s.durations[itemCode])
+	return 1, nil
+}
+
+func assertDurationNear(t *testing.T, expected time.Duration, actual time.Duration, msg string) {
+	t.Helper()
+	if math.Abs(float64(actual-expected)) > float64(expected)/10 {
+		t.Error(msg, fmt.Sprintf("expected about : %v, got : %v", expected, actual))
+	}
+}
+
+// Check that the latency stats of known service call durations are reported
+func TestLatencyStats_SummarizesServiceCalls(t *testing.T) {
+	clock := newFakeClock()
+	service := &slowPriceService{clock: clock, durations: map[string]time.Duration{}}
+	itemCodes := make([]string, 100)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("p%d", i+1)
+		service.durations[itemCodes[i]] = time.Duration(i+1) * time.Millisecond
+	}
+	cache := NewTransparentCache(service, WithClock(clock), WithMaxConcurrency(1))
+	if stats := cache.LatencyStats(); stats != (LatencyStats{}) {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+	if _, err := cache.GetPricesFor(itemCodes...); err != nil {
+		t.Fatal("error getting prices", err)
+	}
+	stats := cache.LatencyStats()
+	assertInt(t, 100, int(stats.Count), "wrong number of calls")
+	assertInt(t, int(time.Millisecond), int(stats.Min), "wrong min latency")
+	assertInt(t, int(100*time.Millisecond), int(stats.Max), "wrong max latency")
+	assertInt(t, int(50500*time.Microsecond), int(stats.Mean), "wrong mean latency")
+	assertDurationNear(t, 50*time.Millisecond, stats.P50, "wrong p50 latency")
+	assertDurationNear(t, 95*time.Millisecond, stats.P95, "wrong p95 latency")
+	assertDurationNear(t, 99*time.Millisecond, stats.P99, "wrong p99 latency")
+}
@@ -0,0 +1,26 @@
+package main
+
+// Logger is what the cache logs its decisions to, see WithLogger
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+}
+
+// Log the event, the logger must be set
+func (c *TransparentCache[V]) logEvent(itemCode string, eventType EventType) {
+	switch eventType {
+	case EventHit:
+		c.logger.Debugf("cache hit for [%v]", itemCode)
+	case EventMiss:
+		c.logger.Debugf("cache miss for [%v], getting it from the service", itemCode)
+	case EventStale:
+		c.logger.Infof("serving the stale price for [%v]", itemCode)
+	case EventEvict:
+		c.logger.Infof("evicted [%v]", itemCode)
+	case EventRefresh:
+		c.logger.Debugf("refreshed [%v] in the background", itemCode)
+	case EventFallback:
+		c.logger.Warnf("got [%v] from the fallback service", itemCode)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// capturingLogger keeps the messages logged, prefixed by their level
+type capturingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *capturingLogger) log(level string, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *capturingLogger) Debugf(format string, args ...any) { l.log("debug", format, args...) }
+func (l *capturingLogger) Infof(format string, args ...any)  { l.log("info", format, args...) }
+func (l *capturingLogger) Warnf(format string, args ...any)  { l.log("warn", format, args...) }
+
+func (l *capturingLogger) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+// Check that the logger gets the decisions of a scripted sequence
+func TestWithLogger_LogsDecisions(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("p2 error")},
+		},
+	}
+	logger := &capturingLogger{}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithLogger(logger))
+	getPriceWithNoErr(t, cache, "p1")
+	getPriceWithNoErr(t, cache, "p1")
+	if _, err := cache.GetPriceFor("p2"); err == nil {
+		t.Error("expected error, got nil")
+	}
+	cache.Invalidate("p1")
+	getPriceWithNoErr(t, cache, "p1")
+	cache.InvalidateAll()
+	assertStrings(t, []string{
+		"debug: cache miss for [p1], getting it from the service",
+		"debug: cache hit for [p1]",
+		"debug: cache miss for [p2], getting it from the service",
+		"warn: service error for [p2] : p2 error",
+		"info: evicted [p1]",
+		"debug: cache miss for [p1], getting it from the service",
+		"info: evicted [p1]",
+	}, logger.get(), "wrong messages logged")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru keeps track of the order in which item codes were accessed, the least recently used first
+// It has its own lock, so cache hits can mark items as used while holding just the cache read lock
+type lru struct {
+	sync.Mutex
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{order: list.New(), elements: map[string]*list.Element{}}
+}
+
+// Mark the item code as the most recently used
+func (l *lru) touch(itemCode string) {
+	l.Lock()
+	defer l.Unlock()
+	if element, ok := l.elements[itemCode]; ok {
+		l.order.MoveToBack(element)
+		return
+	}
+	l.elements[itemCode] = l.order.PushBack(itemCode)
+}
+
+// Stop tracking the item code
+func (l *lru) remove(itemCode string) {
+	l.Lock()
+	defer l.Unlock()
+	if element, ok := l.elements[itemCode]; ok {
+		l.order.Remove(element)
+		delete(l.elements, itemCode)
+	}
+}
+
+// Get the least recently used item code
+func (l *lru) oldest() (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	element := l.order.Front()
+	if element == nil {
+		return "", false
+	}
+	return element.Value.(string), true
+}
+
+// Get up to n of the least recently used item codes, the least recently used first
+func (l *lru) oldestN(n int) []string {
+	l.Lock()
+	defer l.Unlock()
+	itemCodes := make([]string, 0, n)
+	for element := l.order.Front(); element != nil && len(itemCodes) < n; element = element.Next() {
+		itemCodes = append(itemCodes, element.Value.(string))
+	}
+	return itemCodes
+}
+
+// Stop tracking every item code
+func (l *lru) clear() {
+	l.Lock()
+	defer l.Unlock()
+	l.order.Init()
+	l.elements = map[string]*list.Element{}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+// Check that the least recently used prices are evicted once the cache is full
+func TestMaxEntries_EvictsLeastRecentlyUsed(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 9, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxEntries(2))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	// p1 is used again, so p2 becomes the least recently used
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 9, getPriceWithNoErr(t, cache, "p3"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+	assertInt(t, 1, int(cache.Stats().Evictions), "wrong number of evictions")
+	// p1 and p3 are still cached, p2 was evicted
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 9, getPriceWithNoErr(t, cache, "p3"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertInt(t, 4, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the cache is bounded by the estimated size of its prices rather than by their number
+func TestWithMaxBytes_EvictsAtByteThreshold(t *testing.T) {
+	estimate := func(itemCode string, price float64) int64 { return int64(price) }
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxBytes(100), WithSizeEstimator(estimate))
+	cache.Set("p1", 40)
+	cache.Set("p2", 50)
+	assertInt(t, 2, cache.Len(), "wrong number of cached prices")
+	// 40 + 50 + 20 is over the limit, the least recently used price goes
+	cache.Set("p3", 20)
+	assertStrings(t, []string{"p2", "p3"}, sortedKeys(cache), "wrong cached item codes")
+	// many small prices fit where a couple of big ones didn't
+	for _, itemCode := range []string{"p4", "p5", "p6"} {
+		cache.Set(itemCode, 10)
+	}
+	assertStrings(t, []string{"p2", "p3", "p4", "p5", "p6"}, sortedKeys(cache), "wrong cached item codes")
+	// replacing a price accounts for the size of the new one only
+	cache.Set("p2", 5)
+	cache.Set("p7", 40)
+	assertStrings(t, []string{"p2", "p3", "p4", "p5", "p6", "p7"}, sortedKeys(cache), "wrong cached item codes")
+	assertInt(t, 95, int(cache.bytes.Load()), "wrong estimated size")
+	cache.InvalidateAll()
+	assertInt(t, 0, int(cache.bytes.Load()), "wrong estimated size after invalidating")
+}
+
+// Get the cached item codes in order
+func sortedKeys(cache *PriceCache) []string {
+	keys := cache.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// Check that pinned prices survive a cache filled beyond its limit and the janitor, until unpinned
+func TestPin_ExemptsFromEviction(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxEntries(2), WithMaxAge(time.Minute), WithClock(clock))
+	cache.Pin("p1")
+	cache.Set("p1", 5)
+	cache.Set("p2", 7)
+	cache.Pin("p2")
+	for _, itemCode := range []string{"p3", "p4", "p5"} {
+		cache.Set(itemCode, 9)
+	}
+	// only pinned prices are left over the limit, eviction stops there
+	assertStrings(t, []string{"p1", "p2"}, sortedKeys(cache), "wrong cached item codes")
+	clock.Advance(time.Minute)
+	assertInt(t, 0, len(cache.sweep()), "wrong number of swept prices")
+	assertInt(t, 2, cache.Len(), "wrong number of cached prices")
+	// once unpinned the item is evicted as any other, it counts as used when unpinned
+	cache.Unpin("p1")
+	cache.Set("p3", 9)
+	assertStrings(t, []string{"p2", "p3"}, sortedKeys(cache), "wrong cached item codes")
+	cache.Unpin("p2")
+	cache.Set("p4", 9)
+	assertStrings(t, []string{"p2", "p4"}, sortedKeys(cache), "wrong cached item codes")
+}
+
+// Check that under pressure the expensive items are kept over the cheap ones, even when used less recently
+func TestWithCostFunc_KeepsExpensiveItems(t *testing.T) {
+	cost := func(itemCode string, price float64) int {
+		if itemCode[0] == 'e' {
+			return 10
+		}
+		return 1
+	}
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxEntries(3), WithCostFunc(cost))
+	cache.Set("e1", 50)
+	cache.Set("e2", 70)
+	for _, itemCode := range []string{"c1", "c2", "c3", "c4"} {
+		cache.Set(itemCode, 5)
+	}
+	assertStrings(t, []string{"c4", "e1", "e2"}, sortedKeys(cache), "wrong cached item codes")
+	assertInt(t, 3, int(cache.Stats().Evictions), "wrong number of evictions")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the service call latency histogram
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics counts the cache events it is given as an event hook, so they can be scraped by Prometheus
+// Use it with WithEventHook(metrics.Hook), it exposes the metrics in the Prometheus text format by itself
+// so the cache doesn't depend on the Prometheus client
+// A prometheus.Collector adapter is descoped until the repo is a module that can require the client
+type Metrics struct {
+	namespace     string
+	buckets       []float64
+	hits          atomic.Uint64 // fresh and stale prices returned from the cache
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	serviceErrors atomic.Uint64
+	latencyMu     sync.Mutex
+	bucketCounts  []uint64 // calls per bucket, not cumulative
+	latencyCount  uint64
+	latencySum    float64
+}
+
+// MetricsSnapshot is the value of every metric at some point
+type MetricsSnapshot struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	ServiceErrors uint64
+	Buckets       map[float64]uint64 // cumulative count of calls by upper bound, in seconds
+	LatencyCount  uint64
+	LatencySum    float64 // in seconds
+}
+
+// NewMetrics creates the metrics, named with the namespace as prefix
+// The latency buckets are DefaultLatencyBuckets unless others are given
+func NewMetrics(namespace string, buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{namespace: namespace, buckets: buckets, bucketCounts: make([]uint64, len(buckets))}
+}
+
+// Hook is the event hook updating the metrics
+func (m *Metrics) Hook(ev CacheEvent) {
+	switch ev.Type {
+	case EventHit, EventStale:
+		m.hits.Add(1)
+	case EventMiss:
+		m.misses.Add(1)
+	case EventEvict:
+		m.evictions.Add(1)
+	case EventServiceCall:
+		if ev.Err != nil {
+			m.serviceErrors.Add(1)
+		}
+		m.observe(ev.Duration.Seconds())
+	}
+}
+
+// Record a service call latency in the histogram
+func (m *Metrics) observe(seconds float64) {
+	m.latencyMu.Lock()
+	defer m.latencyMu.Unlock()
+	m.latencyCount++
+	m.latencySum += seconds
+	if i := sort.SearchFloat64s(m.buckets, seconds); i < len(m.buckets) {
+		m.bucketCounts[i]++
+	}
+}
+
+// Snapshot returns the current value of every metric
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{
+		Hits:          m.hits.Load(),
+		Misses:        m.misses.Load(),
+		Evictions:     m.evictions.Load(),
+		ServiceErrors: m.serviceErrors.Load(),
+		Buckets:       make(map[float64]uint64, len(m.buckets)),
+	}
+	m.latencyMu.Lock()
+	defer m.latencyMu.Unlock()
+	var cumulative uint64
+	for i, bound := range m.buckets {
+		cumulative += m.bucketCounts[i]
+		s.Buckets[bound] = cumulative
+	}
+	s.LatencyCount = m.latencyCount
+	s.LatencySum = m.latencySum
+	return s
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	s := m.Snapshot()
+	var written int64
+	write := func(format string, args ...any) error {
+		n, err := fmt.Fprintf(w, format, args...)
+		written += int64(n)
+		return err
+	}
+	counters := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"hits_total", "Prices returned from the cache.", s.Hits},
+		{"misses_total", "Prices that had to be got from the service.", s.Misses},
+		{"evictions_total", "Prices removed from the cache.", s.Evictions},
+		{"service_errors_total", "Service calls that returned an error.", s.ServiceErrors},
+	}
+	for _, counter := range counters {
+		name := m.name(counter.name)
+		if err := write("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, counter.help, name, name, counter.value); err != nil {
+			return written, err
+		}
+	}
+	name := m.name("service_call_duration_seconds")
+	if err := write("# HELP %s Duration of the service calls.\n# TYPE %s histogram\n", name, name); err != nil {
+		return written, err
+	}
+	for _, bound := range m.buckets {
+		if err := write("%s_bucket{le=\"%g\"} %d\n", name, bound, s.Buckets[bound]); err != nil {
+			return written, err
+		}
+	}
+	err := write("%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, s.LatencyCount, name, s.LatencySum, name, s.LatencyCount)
+	return written, err
+}
+
+// ServeHTTP lets the metrics be scraped by Prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// Get the full name of a metric
+func (m *Metrics) name(metric string) string {
+	if m.namespace == "" {
+		return metric
+	}
+	return m.namespace + "_" + metric
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Check that scraping the metrics gives the counters and the latency histogram of the cache
+func TestMetrics_Scrape(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: errors.New("some error")},
+		},
+	}
+	clock := newFakeClock()
+	metrics := NewMetrics("prices", 0.5, 1)
+	cache := NewTransparentCache(mockService, WithClock(clock), WithMaxEntries(1),
+		WithEventHook(func(ev CacheEvent) {
+			// every call takes 0.7 seconds, so it falls in the 1 second bucket
+			if ev.Type == EventServiceCall {
+				ev.Duration = 700 * time.Millisecond
+			}
+			metrics.Hook(ev)
+		}))
+	getPriceWithNoErr(t, cache, "p1")
+	getPriceWithNoErr(t, cache, "p1")
+	if _, err := cache.GetPriceFor("p2"); err == nil {
+		t.Fatal("expected error for p2")
+	}
+	cache.Set("p3", 9)
+
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+	response, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal("error scraping metrics", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal("error reading metrics", err)
+	}
+	for _, line := range []string{
+		"# TYPE prices_hits_total counter",
+		"prices_hits_total 1",
+		"prices_misses_total 2",
+		"prices_evictions_total 1",
+		"prices_service_errors_total 1",
+		"# TYPE prices_service_call_duration_seconds histogram",
+		`prices_service_call_duration_seconds_bucket{le="0.5"} 0`,
+		`prices_service_call_duration_seconds_bucket{le="1"} 2`,
+		`prices_service_call_duration_seconds_bucket{le="+Inf"} 2`,
+		"prices_service_call_duration_seconds_sum 1.4",
+		"prices_service_call_duration_seconds_count 2",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Error("missing metric line", line, "in", string(body))
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+// Get the key the item is cached under, as told by WithKeyNormalizer
+func (c *TransparentCache[V]) key(itemCode string) string {
+	if c.keyNormalizer == nil {
+		return itemCode
+	}
+	return c.keyNormalizer(itemCode)
+}
+
+// Get the keys the items are cached under, in the same order, the item codes are left alone
+func (c *TransparentCache[V]) keys(itemCodes []string) []string {
+	if c.keyNormalizer == nil {
+		return itemCodes
+	}
+	keys := make([]string, len(itemCodes))
+	for i, itemCode := range itemCodes {
+		keys[i] = c.keyNormalizer(itemCode)
+	}
+	return keys
+}
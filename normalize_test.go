@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func canonicalItemCode(itemCode string) string {
+	return strings.ToUpper(strings.TrimSpace(itemCode))
+}
+
+func TestWithKeyNormalizer_SharesTheCanonicalKey(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"ABC": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithKeyNormalizer(canonicalItemCode))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "abc"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, " ABC "), "wrong price returned")
+	assertFloats(t, []float64{5, 5, 5}, getPricesWithNoErr(t, cache, "ABC", "Abc", "abc\t"), "wrong prices returned")
+	prices, err := cache.GetMany("abc", " ABC")
+	if err != nil || len(prices) != 2 || prices["abc"] != 5 || prices[" ABC"] != 5 {
+		t.Errorf("expected the prices keyed by the given codes, got %v, error %v", prices, err)
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	assertStrings(t, []string{"ABC"}, sortedKeys(cache), "wrong cached item codes")
+
+	cache.Set(" def", 7)
+	if price, _, ok := cache.Peek("DEF "); !ok || price != 7 {
+		t.Errorf("expected the set price, got %v", price)
+	}
+	if !cache.Invalidate("Def") {
+		t.Error("the set price should have been invalidated")
+	}
+	assertInt(t, 1, cache.InvalidatePrefix("a"), "wrong number of invalidated prices")
+	assertInt(t, 0, cache.Len(), "wrong number of cached prices")
+}
+
+// Check that every path normalizes the item codes exactly once, with a normalizer which is not idempotent
+func TestWithKeyNormalizer_NormalizesOnce(t *testing.T) {
+	namespaced := func(itemCode string) string { return "ns:" + itemCode }
+	var mu sync.Mutex
+	var asked []string
+	service := priceFunc(func(itemCode string) (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		asked = append(asked, itemCode)
+		return 5, nil
+	})
+	cache := NewTransparentCache(service, WithMaxAge(time.Minute), WithKeyNormalizer(namespaced))
+	getPriceWithNoErr(t, cache, "a")
+	getPricesWithNoErr(t, cache, "b", "c")
+	getPricesWithNoErr(t, cache, "d")
+	cache.GetPriceForOrDefault("e", 0)
+	if _, err := cache.GetPriceForEventual("f"); err != nil {
+		t.Fatal("error getting price", err)
+	}
+	if _, err := cache.GetPricesForContext(context.Background(), "g"); err != nil {
+		t.Fatal("error getting prices", err)
+	}
+	sort.Strings(asked)
+	expected := []string{"ns:a", "ns:b", "ns:c", "ns:d", "ns:e", "ns:f", "ns:g"}
+	assertStrings(t, expected, asked, "wrong item codes asked to the service")
+	assertStrings(t, expected, sortedKeys(cache), "wrong cached item codes")
+
+	batchService := &mockBatchPriceService{mockPriceService: mockPriceService{
+		mockResults: map[string]mockResult{"ns:b": {price: 5}, "ns:c": {price: 7}},
+	}}
+	batchCache := NewTransparentCache(batchService, WithMaxAge(time.Minute), WithKeyNormalizer(namespaced))
+	assertFloats(t, []float64{5, 7}, getPricesWithNoErr(t, batchCache, "b", "c"), "wrong prices from the batch service")
+	assertFloat(t, 7, getPriceWithNoErr(t, batchCache, "c"), "wrong price of an item got in a batch")
+	assertStrings(t, []string{"ns:b", "ns:c"}, sortedKeys(batchCache), "wrong cached item codes")
+}
@@ -0,0 +1,470 @@
+package main
+
+import "time"
+
+// DefaultMaxAge is how long prices are cached unless told otherwise
+const DefaultMaxAge = time.Minute
+
+// DefaultMaxConcurrency is the number of service calls GetPricesFor runs at once unless told otherwise
+const DefaultMaxConcurrency = 16
+
+// Option configures a TransparentCache when creating it
+type Option func(*options)
+
+// options are the tunables of the cache, filled by the options given to NewTransparentCache
+type options struct {
+	maxAge           time.Duration
+	maxEntries       int
+	maxConcurrency   int
+	clock            Clock
+	staleGrace       time.Duration
+	maxStaleOnError  time.Duration
+	ttlClassifier    func(itemCode string) time.Duration
+	keyNormalizer    func(itemCode string) string
+	snapshotFile     string
+	snapshotOnClose  bool
+	defaultCurrency  string
+	transform        any // func(string, V) V of the cache values
+	refreshWorkers   int
+	refreshQueueSize int
+	negativeTTL      time.Duration
+	refreshAhead     float64
+	expiryJitter     float64
+	eventHook        func(ev CacheEvent)
+	retryAttempts    int
+	retryBaseDelay   time.Duration
+	isPermanent      func(err error) bool
+	shards           int
+	shardHasher      func(key string) uint64
+	lockStripes      int
+	initialPrices    any // map[string]V of the cache values
+	initialTimes     map[string]time.Time
+	janitorEvery     time.Duration
+	breakerAfter     int
+	breakerFor       time.Duration
+	rateLimit        int
+	rateBurst        int
+	store            any // Store[V] of the cache values
+	priceScale       *int
+	cacheableError   any // func(error) (V, bool) of the cache values
+	healthProbe      string
+	tracer           Tracer
+	adaptiveMin      time.Duration
+	adaptiveMax      time.Duration
+	isNotFound       func(err error) bool
+	serviceTimeout   time.Duration
+	snapshotBatches  bool
+	fallback         any // PriceService or Service[V] of the cache values
+	demoteAfter      time.Duration
+	logger           Logger
+	onError          func(itemCode string, err error)
+	maxBytes         int64
+	sizeEstimator    any // func(string, V) int64 of the cache values
+	costFunc         any // func(string, V) int of the cache values
+	debugInvariants  bool
+}
+
+func defaultOptions() options {
+	return options{
+		maxAge:           DefaultMaxAge,
+		maxConcurrency:   DefaultMaxConcurrency,
+		clock:            realClock{},
+		shards:           DefaultShards,
+		shardHasher:      DefaultShardHasher,
+		lockStripes:      DefaultLockStripes,
+		refreshWorkers:   DefaultRefreshWorkers,
+		refreshQueueSize: DefaultRefreshQueueSize,
+	}
+}
+
+// WithMaxAge sets how long a price is returned from the cache before getting it again from the service
+// A maxAge not above zero means prices never expire, they are only removed by eviction or invalidation
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithMaxEntries bounds the cache to maxEntries prices, evicting the least recently used ones
+// A maxEntries lower than one means the cache is not bounded
+func WithMaxEntries(maxEntries int) Option {
+	return func(o *options) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// WithMaxConcurrency sets how many service calls GetPricesFor runs at once
+// A maxConcurrency lower than one falls back to DefaultMaxConcurrency
+func WithMaxConcurrency(maxConcurrency int) Option {
+	return func(o *options) {
+		if maxConcurrency < 1 {
+			maxConcurrency = DefaultMaxConcurrency
+		}
+		o.maxConcurrency = maxConcurrency
+	}
+}
+
+// WithClock sets the clock used to stamp and expire prices, the system time is used by default
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithStaleWhileRevalidate keeps returning an expired price for up to staleGrace after maxAge,
+// while the price is refreshed from the service in the background
+// After staleGrace the price is got from the service before returning, as usual
+func WithStaleWhileRevalidate(staleGrace time.Duration) Option {
+	return func(o *options) {
+		o.staleGrace = staleGrace
+	}
+}
+
+// WithTTLClassifier sorts the items into tiers, each fresh for the TTL told by classify instead of maxAge
+// Items it returns 0 for keep using maxAge, or the adaptive TTL, and items set with their own TTL keep it
+// It is asked on every read, with the lock of the item held, so it must be quick and not use the cache
+func WithTTLClassifier(classify func(itemCode string) time.Duration) Option {
+	return func(o *options) {
+		o.ttlClassifier = classify
+	}
+}
+
+// WithMaxStaleOnError returns the cached price instead of the service error when it is not older than maxStale
+// Unlike WithStaleWhileRevalidate, expired prices are only returned once the service failed getting them
+// Past maxStale the service error is returned, as usual
+func WithMaxStaleOnError(maxStale time.Duration) Option {
+	return func(o *options) {
+		o.maxStaleOnError = maxStale
+	}
+}
+
+// WithNegativeTTL remembers service errors for negativeTTL, returning them without calling the service again
+// By default errors are not cached
+func WithNegativeTTL(negativeTTL time.Duration) Option {
+	return func(o *options) {
+		o.negativeTTL = negativeTTL
+	}
+}
+
+// WithRefreshAhead refreshes a price in the background when it is read with less than fraction of maxAge left,
+// so hot items get renewed before expiring while the current price is still returned
+// For example a fraction of 0.1 with a maxAge of a minute refreshes prices read in their last 6 seconds
+func WithRefreshAhead(fraction float64) Option {
+	return func(o *options) {
+		o.refreshAhead = fraction
+	}
+}
+
+// WithExpiryJitter randomizes the maxAge of each stored price by up to plus or minus fraction of it,
+// so prices stored at the same time don't all expire together
+func WithExpiryJitter(fraction float64) Option {
+	return func(o *options) {
+		o.expiryJitter = fraction
+	}
+}
+
+// WithEventHook calls hook for every hit, miss, eviction, background refresh and stale price returned
+// The hook is called without holding any lock of the cache, so it can use the cache
+func WithEventHook(hook func(ev CacheEvent)) Option {
+	return func(o *options) {
+		o.eventHook = hook
+	}
+}
+
+// WithRetry makes up to maxAttempts calls to the service for a price, waiting baseDelay before the first retry
+// and doubling the wait before each of the next ones
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = maxAttempts
+		o.retryBaseDelay = baseDelay
+	}
+}
+
+// WithPermanentErrors tells which service errors are not worth retrying, they are returned right away
+func WithPermanentErrors(isPermanent func(err error) bool) Option {
+	return func(o *options) {
+		o.isPermanent = isPermanent
+	}
+}
+
+// WithShards splits the cached items into shards, each with its own lock, to reduce contention
+// The count is rounded up to a power of two, a count of one means a single lock for the whole cache
+func WithShards(count int) Option {
+	return func(o *options) {
+		if count < 1 {
+			count = DefaultShards
+		}
+		o.shards = count
+	}
+}
+
+// WithLockStripes splits the fetches in flight into stripes, each with its own lock, so the fetches of distinct items
+// don't wait on each other to start or finish while the ones of the same item are still shared
+// The count is rounded up to a power of two, and is independent of the number of shards
+func WithLockStripes(count int) Option {
+	return func(o *options) {
+		if count < 1 {
+			count = DefaultLockStripes
+		}
+		o.lockStripes = count
+	}
+}
+
+// WithShardHasher picks the shard of each item by the hash of its key, instead of DefaultShardHasher,
+// for item codes hashing poorly, the low bits of the hash tell the shard
+func WithShardHasher(hash func(key string) uint64) Option {
+	return func(o *options) {
+		if hash == nil {
+			hash = DefaultShardHasher
+		}
+		o.shardHasher = hash
+	}
+}
+
+// WithInitialPrices warms the cache with prices, as if they were just got from the service
+// The prices must be of the type of the cache values, map[string]float64 for NewTransparentCache
+func WithInitialPrices[V any](prices map[string]V) Option {
+	return WithInitialPricesAt(prices, nil)
+}
+
+// WithInitialPricesAt warms the cache with prices got at the given times, items without a time are taken as just got
+// Prices already older than maxAge are stale from the start
+func WithInitialPricesAt[V any](prices map[string]V, cachedAt map[string]time.Time) Option {
+	return func(o *options) {
+		o.initialPrices = prices
+		o.initialTimes = cachedAt
+	}
+}
+
+// WithJanitor removes the expired prices and errors every interval in the background, so they don't take memory forever
+// Prices within the stale grace are kept, the janitor stops when the cache is closed
+func WithJanitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitorEvery = interval
+	}
+}
+
+// WithCircuitBreaker stops calling the service for cooldown after threshold consecutive failures
+// Meanwhile prices are answered from the cache however old they are, or fail fast with ErrCircuitOpen,
+// then a single trial call probes whether the service recovered
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.breakerAfter = threshold
+		o.breakerFor = cooldown
+	}
+}
+
+// WithRateLimit makes at most rps calls per second to the service, with bursts of up to burst calls
+// Calls over the limit wait for their turn, or until their context is done
+func WithRateLimit(rps int, burst int) Option {
+	return func(o *options) {
+		o.rateLimit = rps
+		o.rateBurst = burst
+	}
+}
+
+// WithStore reads through and writes through the store, so cached prices survive restarts
+// On a miss a fresh price in the store is used before calling the service, and every price got from the service is saved
+// The store must be of the type of the cache values, Store[float64] for NewTransparentCache
+func WithStore[V any](store Store[V]) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithPriceScale rounds the prices to that many decimal places, half to even, before caching and returning them
+// It only applies to caches of float64 prices
+func WithPriceScale(decimals int) Option {
+	return func(o *options) {
+		o.priceScale = &decimals
+	}
+}
+
+// WithCacheableError caches a value in place of the service errors the classifier accepts
+// When it returns true for an error the value is stored and returned as if the service returned it
+// The value must be of the type of the cache values, float64 for NewTransparentCache
+func WithCacheableError[V any](classify func(err error) (V, bool)) Option {
+	return func(o *options) {
+		o.cacheableError = classify
+	}
+}
+
+// WithHealthProbeItem sets the item code HealthCheck gets from the service to tell whether it is reachable
+func WithHealthProbeItem(itemCode string) Option {
+	return func(o *options) {
+		o.healthProbe = itemCode
+	}
+}
+
+// WithTracer traces every GetPriceFor, telling whether it was a cache hit, and every call to the service
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithAdaptiveTTL learns how long to cache each item from how often its price changes, instead of using maxAge
+// Items start cached for minTTL, which doubles every time a refresh gets the same price, up to maxTTL,
+// and goes back to minTTL as soon as the price changes
+func WithAdaptiveTTL(minTTL, maxTTL time.Duration) Option {
+	return func(o *options) {
+		if maxTTL < minTTL {
+			maxTTL = minTTL
+		}
+		o.adaptiveMin = minTTL
+		o.adaptiveMax = maxTTL
+	}
+}
+
+// WithNotFoundDetector tells apart the service errors for items that don't exist, which get ErrItemNotFound
+// in their chain, from the others, which get ErrServiceUnavailable
+// With negative caching only the not found errors are cached
+func WithNotFoundDetector(isNotFound func(err error) bool) Option {
+	return func(o *options) {
+		o.isNotFound = isNotFound
+	}
+}
+
+// WithServiceTimeout bounds each call to the service, failing it with ErrServiceTimeout once the timeout is over
+// Every retry attempt gets its own timeout, and timed out calls are not negatively cached
+func WithServiceTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.serviceTimeout = timeout
+	}
+}
+
+// WithSnapshotBatches makes every batch of GetPricesFor answer from the cache as it was when the batch started,
+// so the prices cached then are returned even if they are invalidated while the batch runs
+// By default each item is looked up live when its turn comes, seeing what happened since the batch started
+func WithSnapshotBatches() Option {
+	return func(o *options) {
+		o.snapshotBatches = true
+	}
+}
+
+// WithFallbackService fails over to another price service for the prices the actual service fails to get,
+// the error is only returned when both fail, and prices got from the fallback are cached as any other
+func WithFallbackService(service PriceService) Option {
+	return func(o *options) {
+		o.fallback = service
+	}
+}
+
+// WithFallback is WithFallbackService for generic caches, the service must be of the type of the cache values
+func WithFallback[V any](service Service[V]) Option {
+	return func(o *options) {
+		o.fallback = service
+	}
+}
+
+// WithDemotion moves the items neither written nor read for window to a compact storage every window, to save memory
+// on large sets of cold items, demoted items keep when they were cached in whole seconds only and read as any other
+// Writing an item, by a refresh or a Set, promotes it back, reading it only keeps it from being demoted
+func WithDemotion(window time.Duration) Option {
+	return func(o *options) {
+		o.demoteAfter = window
+	}
+}
+
+// WithLogger logs the decisions of the cache: hits and misses at debug level, stale prices and evictions at info level
+// and service errors at warn level, nothing is formatted without a logger
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithOnError calls onError with every failed service call, background refreshes included, whether a caller is
+// waiting for the price or not, so upstream failures can be watched from a single place
+// Every attempt is reported when retrying, every item of a failed batch call is, and calls given up by their caller
+// are not; onError is never called while holding a lock
+func WithOnError(onError func(itemCode string, err error)) Option {
+	return func(o *options) {
+		o.onError = onError
+	}
+}
+
+// WithMaxBytes bounds the estimated size of the cached prices to maxBytes, evicting the least recently used ones
+// The size of each price is DefaultEntrySize unless WithSizeEstimator tells otherwise, it can be combined with WithMaxEntries
+func WithMaxBytes(maxBytes int64) Option {
+	return func(o *options) {
+		o.maxBytes = maxBytes
+	}
+}
+
+// WithSizeEstimator estimates the size in bytes of each cached price for WithMaxBytes
+// The estimator must be of the type of the cache values, func(string, float64) int64 for NewTransparentCache,
+// and must always give the same size for the same price
+func WithSizeEstimator[V any](estimate func(itemCode string, price V) int64) Option {
+	return func(o *options) {
+		o.sizeEstimator = estimate
+	}
+}
+
+// WithCostFunc makes eviction cost aware, among the CostEvictionWindow least recently used prices the one cheapest
+// to get again is evicted first, so expensive items outlive cheap ones while recency still ages them out
+// The cost function must be of the type of the cache values, func(string, float64) int for NewTransparentCache
+func WithCostFunc[V any](cost func(itemCode string, price V) int) Option {
+	return func(o *options) {
+		o.costFunc = cost
+	}
+}
+
+// WithDebugInvariants checks the internal invariants of the cache after every operation, panicking on a violation
+// Every check locks the whole cache, so it is a development aid, not meant for production
+func WithDebugInvariants() Option {
+	return func(o *options) {
+		o.debugInvariants = true
+	}
+}
+
+// WithDefaultCurrency gets the prices of GetPriceFor in currency, from a CurrencyPriceService
+// They are cached along with the ones got by GetPriceForCurrency in that currency
+func WithDefaultCurrency(currency string) Option {
+	return func(o *options) {
+		o.defaultCurrency = currency
+	}
+}
+
+// WithTransform caches the prices derived by transform from the ones got from the service, or set by hand
+// GetPriceFor returns the derived prices and GetRawPriceFor the ones they were derived from
+// The transform must be deterministic, a derived price is only worked out again when the raw one is got again
+func WithTransform[V any](transform func(itemCode string, raw V) V) Option {
+	return func(o *options) {
+		o.transform = transform
+	}
+}
+
+// WithRefreshQueue runs at most workers background refreshes at once, with up to size more waiting for a worker
+// Refreshes which don't fit are dropped, and counted in Stats, the price is refreshed on a later read instead
+func WithRefreshQueue(workers, size int) Option {
+	return func(o *options) {
+		o.refreshWorkers = max(workers, 1)
+		o.refreshQueueSize = size
+	}
+}
+
+// WithKeyNormalizer caches every item under the code normalize turns it into, so codes told apart only by,
+// say, case or white space share their price and a single service call
+// Every item code given to the cache is normalized, prefixes too, and the service gets the normalized codes
+// The maps returned for several items are keyed by the item codes as they were given
+func WithKeyNormalizer(normalize func(itemCode string) string) Option {
+	return func(o *options) {
+		o.keyNormalizer = normalize
+	}
+}
+
+// WithSnapshotFile restores the snapshot saved to the file at path by SaveSnapshot when the cache is created
+// A missing file is ignored, and so is a file that can't be restored, which is logged when there is a logger
+func WithSnapshotFile(path string) Option {
+	return func(o *options) {
+		o.snapshotFile = path
+	}
+}
+
+// WithSnapshotOnClose saves the snapshot to the file of WithSnapshotFile when the cache is closed
+func WithSnapshotOnClose() Option {
+	return func(o *options) {
+		o.snapshotOnClose = true
+	}
+}
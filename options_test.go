@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Check that without options the cache uses the defaults
+func TestNewTransparentCache_UsesDefaults(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{})
+	if cache.MaxAge() != DefaultMaxAge {
+		t.Error("wrong max age", fmt.Sprintf("expected : %v, got : %v", DefaultMaxAge, cache.MaxAge()))
+	}
+	assertInt(t, DefaultMaxConcurrency, cache.maxConcurrency, "wrong max concurrency")
+	assertInt(t, 0, cache.maxEntries, "wrong max entries")
+}
+
+// Check that WithMaxAge sets how long prices are cached
+func TestWithMaxAge_ExpiresPrices(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(20*time.Millisecond))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	time.Sleep(20 * time.Millisecond)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that WithMaxEntries bounds the cache
+func TestWithMaxEntries_BoundsCache(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxEntries(1))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that WithMaxConcurrency limits the service calls running at once
+func TestWithMaxConcurrency_LimitsServiceCalls(t *testing.T) {
+	mockService := &peakPriceService{callDelay: 10 * time.Millisecond}
+	cache := NewTransparentCache(mockService, WithMaxConcurrency(2))
+	getPricesWithNoErr(t, cache, "p1", "p2", "p3", "p4", "p5")
+	assertInt(t, 2, mockService.getPeak(), "wrong peak of concurrent service calls")
+	assertInt(t, DefaultMaxConcurrency, NewTransparentCache(mockService, WithMaxConcurrency(0)).maxConcurrency, "wrong max concurrency")
+}
+
+// Check that the initial prices are served from the cache until they expire, and old ones are stale from the start
+func TestWithInitialPrices_WarmsCache(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 50, err: nil},
+			"p2": {price: 70, err: nil},
+			"p3": {price: 90, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock),
+		WithInitialPricesAt(map[string]float64{"p1": 5, "p2": 7, "p3": 9},
+			map[string]time.Time{"p2": clock.Now().Add(-30 * time.Second), "p3": clock.Now().Add(-2 * time.Minute)}))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertFloat(t, 90, getPriceWithNoErr(t, cache, "p3"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	clock.Advance(30 * time.Second)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 70, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+
+	descriptions := New[string](&mockDescriptionService{}, WithInitialPrices(map[string]string{"p1": "apple"}))
+	description, err := descriptions.GetPriceFor("p1")
+	if err != nil {
+		t.Fatal("error getting description", err)
+	}
+	assertString(t, "apple", description, "wrong description returned")
+}
+
+// Check that with a zero maxAge cached prices are reused forever, but can still be evicted
+func TestWithMaxAge_ZeroNeverExpires(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(0), WithClock(clock), WithMaxEntries(1))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	clock.Advance(24 * 365 * time.Hour)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	if _, fresh, _ := cache.Peek("p1"); !fresh {
+		t.Error("expected p1 to be fresh")
+	}
+	if expiresAt, ok := cache.ExpiresAt("p1"); !ok || !expiresAt.IsZero() {
+		t.Error("expected p1 to never expire", expiresAt)
+	}
+	cache.sweep()
+	assertInt(t, 1, cache.Len(), "never expiring price removed by the janitor")
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that an error accepted by the classifier is cached as a price
+func TestWithCacheableError_CachesValue(t *testing.T) {
+	errUnpriced := errors.New("unpriced item")
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 0, err: fmt.Errorf("item p1 : %w", errUnpriced)},
+			"p2": {price: 0, err: errors.New("service down")},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithCacheableError(func(err error) (float64, bool) {
+		return 0, errors.Is(err, errUnpriced)
+	}))
+	assertFloat(t, 0, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 0, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	if _, err := cache.GetPriceFor("p2"); err == nil {
+		t.Error("expected the service error")
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	if _, _, ok := cache.Peek("p1"); !ok {
+		t.Error("expected p1 to be cached")
+	}
+}
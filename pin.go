@@ -0,0 +1,38 @@
+package main
+
+// Pin exempts the item from eviction, by the least recently used or size bounds and by the janitor, until unpinned
+// A pinned item is still refreshed as any other when its price expires, and it can still be invalidated
+// Items can be pinned before they are cached, the pin sticks to the item code
+func (c *TransparentCache[V]) Pin(itemCode string) {
+	itemCode = c.key(itemCode)
+	s := c.shardFor(itemCode)
+	s.Lock()
+	defer s.Unlock()
+	s.pinned[itemCode] = struct{}{}
+	if c.recency != nil {
+		c.recency.remove(itemCode)
+	}
+}
+
+// Unpin makes the item evictable again, as the most recently used one
+func (c *TransparentCache[V]) Unpin(itemCode string) {
+	itemCode = c.key(itemCode)
+	s := c.shardFor(itemCode)
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pinned, itemCode)
+	if _, ok := s.prices[itemCode]; ok {
+		c.touch(s, itemCode)
+	}
+}
+
+// Mark the item as just used for the least recently used eviction, unless it is pinned
+// The lock of the shard must be held, for reading at least
+func (c *TransparentCache[V]) touch(s *shard[V], itemCode string) {
+	if c.recency == nil {
+		return
+	}
+	if _, ok := s.pinned[itemCode]; !ok {
+		c.recency.touch(itemCode)
+	}
+}
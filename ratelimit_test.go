@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// timedPriceService records when each call was made
+type timedPriceService struct {
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func (m *timedPriceService) GetPriceFor(itemCode string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, time.Now())
+	return 1, nil
+}
+
+// Check that the service is never called faster than the rate limit, after the initial burst
+func TestWithRateLimit_ThrottlesServiceCalls(t *testing.T) {
+	mockService := &timedPriceService{}
+	cache := NewTransparentCache(mockService, WithRateLimit(100, 2), WithMaxConcurrency(8))
+	itemCodes := make([]string, 12)
+	for i := range itemCodes {
+		itemCodes[i] = fmt.Sprintf("p%d", i)
+	}
+	getPricesWithNoErr(t, cache, itemCodes...)
+	assertInt(t, 12, len(mockService.calls), "wrong number of service calls")
+	// 2 calls right away and the other 10 at 100 per second
+	elapsed := mockService.calls[len(mockService.calls)-1].Sub(mockService.calls[0])
+	if elapsed < 90*time.Millisecond {
+		t.Error("service called over the rate limit", elapsed)
+	}
+}
+
+// Check that waiting for the rate limit gives up when the context is done
+func TestWithRateLimit_RespectsContext(t *testing.T) {
+	mockService := &timedPriceService{}
+	cache := NewTransparentCache(mockService, WithRateLimit(1, 1))
+	getPriceWithNoErr(t, cache, "p1")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cache.GetPriceForContext(ctx, "p2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("didn't stop waiting when the context was done")
+	}
+	assertInt(t, 1, len(mockService.calls), "wrong number of service calls")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrNotRecorded is returned by a ReplayService asked for a call that is not in its recording
+var ErrNotRecorded = errors.New("call not recorded")
+
+// Interaction is a call to a PriceService as it is written by a RecordingService, one JSON object per line
+type Interaction struct {
+	ItemCode string    `json:"itemCode"`
+	Price    float64   `json:"price"`
+	Err      string    `json:"err,omitempty"` // the message of the error, if the call failed
+	At       time.Time `json:"at"`
+}
+
+// RecordingService is a PriceService writing every call made to the service it wraps, to be replayed by a ReplayService
+type RecordingService struct {
+	service PriceService
+	clock   Clock
+	mu      sync.Mutex
+	w       io.Writer
+	err     error // the first error writing the recording
+}
+
+// NewRecordingService records the calls made to service into w
+func NewRecordingService(service PriceService, w io.Writer) *RecordingService {
+	return &RecordingService{service: service, clock: realClock{}, w: w}
+}
+
+// GetPriceFor gets the price from the wrapped service and records the call
+// Failing to write the recording doesn't fail the call, Err tells about it
+func (r *RecordingService) GetPriceFor(itemCode string) (float64, error) {
+	price, err := r.service.GetPriceFor(itemCode)
+	interaction := Interaction{ItemCode: itemCode, Price: price, At: r.clock.Now()}
+	if err != nil {
+		interaction.Err = err.Error()
+	}
+	line, marshalErr := json.Marshal(interaction)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if marshalErr == nil {
+		_, marshalErr = r.w.Write(append(line, '\n'))
+	}
+	if marshalErr != nil && r.err == nil {
+		r.err = fmt.Errorf("recording call for [%v] : %w", itemCode, marshalErr)
+	}
+	return price, err
+}
+
+// Err tells the first error writing the recording, if any
+func (r *RecordingService) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ReplayService is a PriceService answering from the calls recorded by a RecordingService
+// The calls for each item are answered in the order they were recorded, the errors only keep their message
+type ReplayService struct {
+	mu     sync.Mutex
+	byItem map[string][]Interaction
+}
+
+// NewReplayService reads the recording from r
+func NewReplayService(r io.Reader) (*ReplayService, error) {
+	replay := &ReplayService{byItem: make(map[string][]Interaction)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("reading recording : %w", err)
+		}
+		replay.byItem[interaction.ItemCode] = append(replay.byItem[interaction.ItemCode], interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading recording : %w", err)
+	}
+	return replay, nil
+}
+
+// GetPriceFor answers with the next recorded call for the item
+// It fails with ErrNotRecorded when the item was never recorded, or all of its calls were already replayed
+func (r *ReplayService) GetPriceFor(itemCode string) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interactions, ok := r.byItem[itemCode]
+	if !ok {
+		return 0, fmt.Errorf("%w : [%v] was never called", ErrNotRecorded, itemCode)
+	}
+	if len(interactions) == 0 {
+		return 0, fmt.Errorf("%w : every call for [%v] was already replayed", ErrNotRecorded, itemCode)
+	}
+	interaction := interactions[0]
+	r.byItem[itemCode] = interactions[1:]
+	if interaction.Err != "" {
+		return 0, errors.New(interaction.Err)
+	}
+	return interaction.Price, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Run the same session against a cache, telling what it got
+func runRecordedSession(service PriceService, clock *fakeClock) []string {
+	cache := NewTransparentCache(service, WithClock(clock), WithMaxAge(time.Minute))
+	var results []string
+	for _, itemCode := range []string{"p1", "p2", "p1", "p3"} {
+		price, err := cache.GetPriceFor(itemCode)
+		results = append(results, fmt.Sprint(price, err))
+		clock.Advance(40 * time.Second)
+	}
+	return results
+}
+
+func TestReplayService_ReplaysRecordedSession(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+			"p3": {price: 0, err: errors.New("unavailable")},
+		},
+	}
+	var recording bytes.Buffer
+	recorder := NewRecordingService(mockService, &recording)
+	recorded := runRecordedSession(recorder, newFakeClock())
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("error recording: %v", err)
+	}
+
+	replay, err := NewReplayService(&recording)
+	if err != nil {
+		t.Fatalf("error reading the recording: %v", err)
+	}
+	replayed := runRecordedSession(replay, newFakeClock())
+	if fmt.Sprint(recorded) != fmt.Sprint(replayed) {
+		t.Errorf("replay got %v, recorded %v", replayed, recorded)
+	}
+	assertInt(t, 4, mockService.getNumCalls(), "wrong number of recorded calls")
+}
+
+func TestReplayService_FailsOnUnrecordedCalls(t *testing.T) {
+	replay, err := NewReplayService(bytes.NewBufferString(`{"itemCode":"p1","price":5}` + "\n"))
+	if err != nil {
+		t.Fatalf("error reading the recording: %v", err)
+	}
+	if _, err := replay.GetPriceFor("p2"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected a not recorded error for p2, got %v", err)
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, NewTransparentCache(replay), "p1"), "wrong price for p1")
+	if _, err := replay.GetPriceFor("p1"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected a not recorded error once p1 was replayed, got %v", err)
+	}
+}
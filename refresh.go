@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultRefreshWorkers is how many background refreshes run at once unless told otherwise by WithRefreshQueue
+const DefaultRefreshWorkers = 4
+
+// DefaultRefreshQueueSize is how many background refreshes can wait for a worker unless told otherwise by WithRefreshQueue
+const DefaultRefreshQueueSize = 64
+
+// refreshQueue bounds the background refreshes, the ones that don't fit are dropped since a later read asks again
+// Workers are started as refreshes are queued, up to the limit, and exit once the queue is empty
+type refreshQueue struct {
+	sync.Mutex
+	jobs       chan string
+	pending    map[string]struct{} // items queued or being refreshed, so an item is only refreshed once at a time
+	running    int
+	maxWorkers int
+}
+
+func newRefreshQueue(workers, size int) *refreshQueue {
+	return &refreshQueue{jobs: make(chan string, size), pending: map[string]struct{}{}, maxWorkers: workers}
+}
+
+// Refresh the price in the background, unless it is already queued or being got from the service
+// The refresh is dropped, and counted, when the queue is full
+func (c *TransparentCache[V]) refreshAsync(itemCode string) {
+	q := c.refreshes
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.pending[itemCode]; ok {
+		return
+	}
+	select {
+	case q.jobs <- itemCode:
+		q.pending[itemCode] = struct{}{}
+	default:
+		c.stats.droppedRefreshes.Add(1)
+		return
+	}
+	if q.running < q.maxWorkers && c.addBackground() {
+		q.running++
+		go c.refreshWorker()
+	}
+}
+
+// Refresh the queued items one at a time until the queue is empty
+func (c *TransparentCache[V]) refreshWorker() {
+	defer c.background.Done()
+	q := c.refreshes
+	for {
+		q.Lock()
+		var itemCode string
+		select {
+		case itemCode = <-q.jobs:
+		default:
+			// checked under the lock, so an item queued meanwhile starts a new worker
+			q.running--
+			q.Unlock()
+			return
+		}
+		q.Unlock()
+		c.refreshQueued(itemCode)
+		q.Lock()
+		delete(q.pending, itemCode)
+		q.Unlock()
+	}
+}
+
+// Refresh a queued item, a fetch already in flight for it is waited on instead
+func (c *TransparentCache[V]) refreshQueued(itemCode string) {
+	if c.isClosed() {
+		return
+	}
+	c.flightsFor(itemCode).do(c.lifetime, itemCode, func(ctx context.Context) (sourced[V], error) {
+		price, source, err := c.refreshPrice(ctx, itemCode)
+		if err == nil {
+			c.emit(itemCode, EventRefresh)
+		}
+		return sourced[V]{price: price, source: source}, err
+	})
+}
+
+// Refresh gets the price for the item from the service right away, even if the cached one is fresh, and caches it
+// A fetch already in flight for the item is waited on instead of calling the service again
+// While bypassing the cache the price is got from the service and not cached, as GetPriceFor does
+// The service is always called, even when a versioned service tells the version didn't change
+func (c *TransparentCache[V]) Refresh(itemCode string) (V, error) {
+	itemCode = c.key(itemCode)
+	var zero V
+	if c.isClosed() {
+		return zero, ErrClosed
+	}
+	if isEmptyItemCode(itemCode) {
+		return zero, ErrEmptyItemCode
+	}
+	if c.bypass.Load() {
+		price, _, err := c.fetchBypassing(context.Background(), itemCode)
+		return price, err
+	}
+	result, err := c.flightsFor(itemCode).do(context.Background(), itemCode, func(ctx context.Context) (sourced[V], error) {
+		// unlike an expiry, an unchanged version doesn't keep the cached price
+		price, source, err := c.fetchAndStore(ctx, itemCode, "")
+		return sourced[V]{price: price, source: source}, err
+	})
+	return result.price, err
+}
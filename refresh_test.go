@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Check that a flood of refreshes runs at most the workers at once, dropping what doesn't fit in the queue
+func TestWithRefreshQueue_BoundsBackgroundRefreshes(t *testing.T) {
+	mockService := &peakPriceService{callDelay: 50 * time.Millisecond}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithRefreshQueue(2, 3))
+	defer cache.Close()
+	for i := 0; i < 20; i++ {
+		cache.TryGetPriceFor(fmt.Sprintf("p%d", i))
+	}
+	// 3 refreshes queued, and up to 2 more once the workers took them from the queue
+	dropped := int(cache.Stats().DroppedRefreshes)
+	if dropped < 15 || dropped > 17 {
+		t.Errorf("expected between 15 and 17 dropped refreshes, got %v", dropped)
+	}
+	waitFor(t, func() bool { return cache.Len() == 20-dropped }, "the queued refreshes were not done")
+	if peak := mockService.getPeak(); peak > 2 {
+		t.Errorf("expected at most 2 refreshes at once, got %v", peak)
+	}
+	cache.ResetStats()
+	assertInt(t, 0, int(cache.Stats().DroppedRefreshes), "wrong dropped refreshes after reset")
+}
+
+func TestRefresh_GetsThePriceEvenIfFresh(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	mockService.setResult("p1", mockResult{price: 6})
+	price, err := cache.Refresh("p1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFloat(t, 6, price, "wrong refreshed price")
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "the refreshed price should be cached")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	// a failed refresh keeps the cached price
+	mockService.setResult("p1", mockResult{err: errors.New("some error")})
+	if _, err := cache.Refresh("p1"); !errors.Is(err, ErrServiceFailure) {
+		t.Errorf("expected a service failure, got %v", err)
+	}
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "the cached price should be kept")
+}
+
+// Check that a refresh while bypassing the cache gets the price without caching it
+func TestRefresh_Bypassed(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	cache.SetBypass(true)
+	mockService.setResult("p1", mockResult{price: 6})
+	price, err := cache.Refresh("p1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFloat(t, 6, price, "wrong refreshed price")
+	cache.SetBypass(false)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "the price got while bypassing should not be cached")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a refresh joins the fetch in flight for the item instead of calling the service again
+func TestRefresh_JoinsTheFetchInFlight(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+		callDelay: 50 * time.Millisecond,
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	result := cache.GetPriceForAsync("p1")
+	waitFor(t, func() bool { return mockService.getNumCalls() == 1 }, "the fetch didn't start")
+	price, err := cache.Refresh("p1")
+	if err != nil || price != 5 {
+		t.Errorf("expected 5, got %v, error %v", price, err)
+	}
+	<-result
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a refresh gets the price from the service even when a versioned service tells it didn't change
+func TestRefresh_IgnoresAnUnchangedVersion(t *testing.T) {
+	mockService := &versionedPriceService{
+		mockPriceService: mockPriceService{
+			mockResults: map[string]mockResult{
+				"p1": {price: 5, err: nil},
+			},
+		},
+		version: "v1",
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	mockService.setResult("p1", mockResult{price: 6})
+	price, err := cache.Refresh("p1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFloat(t, 6, price, "wrong refreshed price")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
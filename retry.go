@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Call the service, retrying failed calls with exponential backoff when retries are enabled
+// The error of the last attempt is returned once the attempts are exhausted, or right away for permanent errors
+func (c *TransparentCache[V]) fetchWithRetry(ctx context.Context, itemCode string) (V, error) {
+	delay := c.retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		price, err := c.fetchPrice(ctx, itemCode)
+		if err == nil || attempt >= c.retryAttempts || errors.Is(err, ErrCircuitOpen) || (c.isPermanent != nil && c.isPermanent(err)) {
+			return price, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return price, err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyPriceService fails the first calls and then returns the price
+type flakyPriceService struct {
+	mu       sync.Mutex
+	numCalls int
+	failures int
+	err      error
+	price    float64
+}
+
+func (m *flakyPriceService) GetPriceFor(itemCode string) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.numCalls++
+	if m.numCalls <= m.failures {
+		return 0, m.err
+	}
+	return m.price, nil
+}
+
+func (m *flakyPriceService) getNumCalls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.numCalls
+}
+
+// Check that failed calls are retried and the price is eventually cached
+func TestWithRetry_RetriesUntilSuccess(t *testing.T) {
+	mockService := &flakyPriceService{failures: 2, err: fmt.Errorf("transient error"), price: 5}
+	cache := NewTransparentCache(mockService, WithRetry(3, time.Millisecond))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the last error is returned once the attempts are exhausted
+func TestWithRetry_ReturnsErrorAfterLastAttempt(t *testing.T) {
+	mockService := &flakyPriceService{failures: 5, err: fmt.Errorf("transient error"), price: 5}
+	cache := NewTransparentCache(mockService, WithRetry(3, time.Millisecond))
+	if _, err := cache.GetPriceFor("p1"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that permanent errors are not retried
+func TestWithPermanentErrors_DoesNotRetry(t *testing.T) {
+	errNotFound := errors.New("not found")
+	mockService := &flakyPriceService{failures: 5, err: errNotFound, price: 5}
+	cache := NewTransparentCache(mockService, WithRetry(3, time.Millisecond),
+		WithPermanentErrors(func(err error) bool { return errors.Is(err, errNotFound) }))
+	if _, err := cache.GetPriceFor("p1"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the wait between retries gives up when the context is done
+func TestWithRetry_StopsWhenContextIsDone(t *testing.T) {
+	mockService := &flakyPriceService{failures: 5, err: fmt.Errorf("transient error"), price: 5}
+	cache := NewTransparentCache(mockService, WithRetry(3, time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cache.GetPriceForContext(ctx, "p1"); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("retries didn't stop when the context was done")
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
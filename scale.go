@@ -0,0 +1,22 @@
+package main
+
+import "math"
+
+// Get the function rounding prices to decimals places, half to even
+func roundFunc(decimals int) func(price float64) float64 {
+	factor := math.Pow10(decimals)
+	return func(price float64) float64 {
+		if math.IsNaN(price) || math.IsInf(price, 0) {
+			return price
+		}
+		return math.RoundToEven(price*factor) / factor
+	}
+}
+
+// Normalize the price as it must be cached and returned
+func (c *TransparentCache[V]) scale(price V) V {
+	if c.normalize == nil {
+		return price
+	}
+	return c.normalize(price)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Check that prices are rounded half to even on every path into the cache
+func TestWithPriceScale_RoundsPrices(t *testing.T) {
+	cases := map[float64]float64{
+		12.340000000001: 12.34,
+		12.339999999999: 12.34,
+		0.125:           0.12,
+		0.375:           0.38,
+		-1.255:          -1.25,
+		7:               7,
+	}
+	for price, expected := range cases {
+		mockService := &mockPriceService{mockResults: map[string]mockResult{"p1": {price: price}}}
+		cache := NewTransparentCache(mockService, WithPriceScale(2))
+		assertFloat(t, expected, getPriceWithNoErr(t, cache, "p1"), fmt.Sprintf("wrong price returned for %v", price))
+		cached, _, _ := cache.Peek("p1")
+		assertFloat(t, expected, cached, fmt.Sprintf("wrong price cached for %v", price))
+		cache.Set("p2", price)
+		assertFloat(t, expected, getPriceWithNoErr(t, cache, "p2"), fmt.Sprintf("wrong price set for %v", price))
+	}
+	batchService := &mockBatchPriceService{mockPriceService: mockPriceService{mockResults: map[string]mockResult{"p1": {price: 2.5}}}}
+	batchCache := NewTransparentCache(batchService, WithPriceScale(0))
+	assertFloatsInOrder(t, []float64{2}, getPricesWithNoErr(t, batchCache, "p1"), "wrong prices returned")
+}
+
+// Check that the scale is ignored for values which are not prices
+func TestWithPriceScale_IgnoresOtherValues(t *testing.T) {
+	descriptions := New[string](&mockDescriptionService{descriptions: map[string]string{"p1": "apple"}}, WithPriceScale(2))
+	description, err := descriptions.GetPriceFor("p1")
+	if err != nil {
+		t.Fatal("error getting description", err)
+	}
+	assertString(t, "apple", description, "wrong description returned")
+}
+
+// Check that the initial and restored prices are rounded too, as the options are applied before they are cached
+func TestWithPriceScale_RoundsInitialAndRestoredPrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	saved := NewTransparentCache(&mockPriceService{})
+	saved.Set("p2", 2.34567)
+	if err := saved.SaveSnapshot(path); err != nil {
+		t.Fatal("error saving snapshot", err)
+	}
+	cache := NewTransparentCache(&mockPriceService{}, WithInitialPrices(map[string]float64{"p1": 1.23456}),
+		WithSnapshotFile(path), WithPriceScale(2))
+	for itemCode, expected := range map[string]float64{"p1": 1.23, "p2": 2.35} {
+		price, _, ok := cache.Peek(itemCode)
+		if !ok {
+			t.Fatalf("[%v] expected to be cached", itemCode)
+		}
+		assertFloat(t, expected, price, fmt.Sprintf("wrong price cached for %v", itemCode))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import "context"
+
+// Service is a service that we can use to get values for keys
+// Calls to this service are expensive (they take time)
+type Service[V any] interface {
+	Get(key string) (V, error)
+}
+
+// ContextService is an optional variant of Service that can be cancelled through a context
+type ContextService[V any] interface {
+	GetContext(ctx context.Context, key string) (V, error)
+}
+
+// BatchService is an optional variant of Service that can get the values for several keys in a single call
+type BatchService[V any] interface {
+	GetMany(keys []string) (map[string]V, error)
+}
+
+// VersionedService is an optional variant of Service that can cheaply tell the version of the value for a key
+// Expired values whose version didn't change are revalidated without getting them again
+type VersionedService interface {
+	GetVersion(key string) (string, error)
+}
+
+// PriceService is a service that we can use to get prices for the items
+// Calls to this service are expensive (they take time)
+type PriceService interface {
+	GetPriceFor(itemCode string) (float64, error)
+}
+
+// ContextPriceService is an optional variant of PriceService that can be cancelled through a context
+// When the wrapped service implements it, the context given to GetPriceForContext is passed down to it
+type ContextPriceService interface {
+	GetPriceForContext(ctx context.Context, itemCode string) (float64, error)
+}
+
+// BatchPriceService is an optional variant of PriceService that can get the prices for several items in a single call
+// When the wrapped service implements it, GetPricesFor asks it for every item missing in the cache at once
+type BatchPriceService interface {
+	GetPricesFor(itemCodes []string) (map[string]float64, error)
+}
+
+// VersionedPriceService is an optional variant of PriceService that can cheaply tell the version of the price for an item
+// When the wrapped service implements it, an expired price whose version didn't change is kept without getting it again
+type VersionedPriceService interface {
+	GetVersion(itemCode string) (string, error)
+}
+
+// backend is what the cache can do with the actual service, resolved once when the cache is created
+// The optional capabilities are nil when the service doesn't support them
+type backend[V any] struct {
+	get        func(key string) (V, error)
+	getContext func(ctx context.Context, key string) (V, error)
+	getBatch   func(keys []string) (map[string]V, error)
+	getVersion func(key string) (string, error)
+	// only for price services, see CurrencyPriceService
+	getCurrency func(itemCode, currency string) (V, error)
+}
+
+// Resolve the capabilities of a generic service
+func newBackend[V any](service Service[V]) *backend[V] {
+	b := &backend[V]{get: service.Get}
+	if s, ok := service.(ContextService[V]); ok {
+		b.getContext = s.GetContext
+	}
+	if s, ok := service.(BatchService[V]); ok {
+		b.getBatch = s.GetMany
+	}
+	if s, ok := service.(VersionedService); ok {
+		b.getVersion = s.GetVersion
+	}
+	return b
+}
+
+// Resolve the capabilities of a price service, getting the prices in the default currency when there is one
+func newPriceBackend(service PriceService, defaultCurrency string) *backend[float64] {
+	b := &backend[float64]{get: service.GetPriceFor}
+	if s, ok := service.(ContextPriceService); ok {
+		b.getContext = s.GetPriceForContext
+	}
+	if s, ok := service.(BatchPriceService); ok {
+		b.getBatch = s.GetPricesFor
+	}
+	if s, ok := service.(VersionedPriceService); ok {
+		b.getVersion = s.GetVersion
+	}
+	if s, ok := service.(CurrencyPriceService); ok {
+		b.inCurrencies(s.GetPriceForCurrency, defaultCurrency)
+	}
+	return b
+}
+
+// Get the actual service, as it is right now
+func (c *TransparentCache[V]) service() *backend[V] {
+	return c.actualService.Load()
+}
+
+// SetService replaces the actual service, every later fetch uses the new one while fetches in flight finish
+// against the old one, the cached prices are kept
+func (c *TransparentCache[V]) SetService(service Service[V]) {
+	c.actualService.Store(newBackend(service))
+}
+
+// SetService replaces the actual price service as TransparentCache.SetService does
+func (c *PriceCache) SetService(service PriceService) {
+	c.actualService.Store(newPriceBackend(service, c.defaultCurrency))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockDescriptionService is a generic Service returning strings
+type mockDescriptionService struct {
+	mu           sync.Mutex
+	numCalls     int
+	descriptions map[string]string
+}
+
+func (m *mockDescriptionService) Get(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.numCalls++
+	description, ok := m.descriptions[key]
+	if !ok {
+		return "", fmt.Errorf("no description for [%v]", key)
+	}
+	return description, nil
+}
+
+func (m *mockDescriptionService) getNumCalls() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.numCalls
+}
+
+func assertString(t *testing.T, expected string, actual string, msg string) {
+	if expected != actual {
+		t.Error(msg, fmt.Sprintf("expected : %v, got : %v", expected, actual))
+	}
+}
+
+// Check that a cache of strings caches, expires and fetches in batches like the price cache
+func TestNew_CachesStringValues(t *testing.T) {
+	mockService := &mockDescriptionService{
+		descriptions: map[string]string{
+			"p1": "apple",
+			"p2": "banana",
+		},
+	}
+	clock := newFakeClock()
+	cache := New[string](mockService, WithMaxAge(time.Minute), WithClock(clock))
+	description, err := cache.GetPriceFor("p1")
+	if err != nil {
+		t.Error("error getting description for p1")
+	}
+	assertString(t, "apple", description, "wrong description returned")
+	descriptions, err := cache.GetPricesFor("p2", "p1")
+	if err != nil || len(descriptions) != 2 {
+		t.Fatal("error getting descriptions for p2 and p1", err)
+	}
+	assertString(t, "banana", descriptions[0], "wrong description returned")
+	assertString(t, "apple", descriptions[1], "wrong description returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	clock.Advance(time.Minute)
+	cache.GetPriceFor("p1")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+	if _, err := cache.GetPriceFor("p3"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+// Check that swapping the service lets the fetch in flight finish against the old one, and later fetches use the new one
+func TestSetService_SwapsServiceMidFlight(t *testing.T) {
+	oldService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+		callDelay: 50 * time.Millisecond,
+	}
+	newService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 6, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	cache := NewTransparentCache(oldService, WithMaxAge(time.Minute))
+	result := cache.GetPriceForAsync("p1")
+	waitFor(t, func() bool { return oldService.getNumCalls() == 1 }, "the old service was not called")
+	cache.SetService(newService)
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	r := <-result
+	if r.Err != nil {
+		t.Fatalf("unexpected error: %v", r.Err)
+	}
+	assertFloat(t, 5, r.Price, "wrong price returned by the fetch in flight")
+	// the warm prices are kept, and refreshed from the new service
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong cached price returned")
+	cache.Invalidate("p1")
+	assertFloat(t, 6, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 1, oldService.getNumCalls(), "wrong number of old service calls")
+	assertInt(t, 2, newService.getNumCalls(), "wrong number of new service calls")
+}
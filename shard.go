@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultShards is the number of shards the cached items are split into unless told otherwise
+const DefaultShards = 16
+
+// shard holds part of the cached items, with its own lock so items on different shards don't contend
+type shard[V any] struct {
+	sync.RWMutex
+	prices           map[string]V
+	expirationByItem map[string]time.Time
+	compactAt        map[string]int32 // when demoted items were cached, in seconds since compactEpoch
+	failures         map[string]failure
+	jitterByItem     map[string]float64       // factor applied to maxAge for each item when there is expiry jitter
+	ttlByItem        map[string]time.Duration // how long the price is fresh for items set with their own TTL
+	versionByItem    map[string]string        // version of the price, for services telling versions
+	stableByItem     map[string]int           // refreshes in a row without a change, for the adaptive TTL
+	writeByItem      map[string]uint64        // sequence number of the last write of each item
+	costByItem       map[string]int           // how expensive each item is to get again, for cost aware eviction
+	tagByItem        map[string]string        // the group of each tagged item, see SetTag
+	rawByItem        map[string]V             // the price before the transform, for the derived prices
+	pinned           map[string]struct{}      // items exempt from eviction, kept when the shard is reset
+	reads            readSet                  // items read since the last demotion pass, with WithDemotion
+}
+
+func newShard[V any]() *shard[V] {
+	s := &shard[V]{pinned: map[string]struct{}{}}
+	s.reset()
+	return s
+}
+
+// Forget every item of the shard, the lock must be held
+func (s *shard[V]) reset() {
+	s.prices = map[string]V{}
+	s.expirationByItem = map[string]time.Time{}
+	s.compactAt = map[string]int32{}
+	s.failures = map[string]failure{}
+	s.jitterByItem = map[string]float64{}
+	s.ttlByItem = map[string]time.Duration{}
+	s.versionByItem = map[string]string{}
+	s.stableByItem = map[string]int{}
+	s.writeByItem = map[string]uint64{}
+	s.costByItem = map[string]int{}
+	s.tagByItem = map[string]string{}
+	s.rawByItem = map[string]V{}
+}
+
+// Get when the item was cached, whether it was demoted or not, the lock must be held
+func (s *shard[V]) cachedAt(itemCode string) (time.Time, bool) {
+	if cachedAt, ok := s.expirationByItem[itemCode]; ok {
+		return cachedAt, true
+	}
+	if seconds, ok := s.compactAt[itemCode]; ok {
+		return compactEpoch.Add(time.Duration(seconds) * time.Second), true
+	}
+	return time.Time{}, false
+}
+
+// Stamp the item as cached at the given time, promoting it if it was demoted, the lock must be held
+func (s *shard[V]) stamp(itemCode string, cachedAt time.Time) {
+	s.expirationByItem[itemCode] = cachedAt
+	delete(s.compactAt, itemCode)
+}
+
+// Create the shards, their number is rounded up to a power of two so a shard can be picked with a mask
+func newShards[V any](count int) []*shard[V] {
+	size := 1
+	for size < count {
+		size *= 2
+	}
+	shards := make([]*shard[V], size)
+	for i := range shards {
+		shards[i] = newShard[V]()
+	}
+	return shards
+}
+
+// DefaultShardHasher is the FNV-1a hash of the key with its bits mixed at the end, as in murmur3,
+// so the low bits picking the shard depend on every byte, even for codes sharing long prefixes
+func DefaultShardHasher(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// Get the shard holding the item, picked by the low bits of the hash of its code
+func (c *TransparentCache[V]) shardFor(itemCode string) *shard[V] {
+	return c.shards[c.shardHasher(itemCode)&uint64(len(c.shards)-1)]
+}
+
+// Lock every shard, in order so it can't deadlock with another caller doing the same
+func (c *TransparentCache[V]) lockAll() {
+	for _, s := range c.shards {
+		s.Lock()
+	}
+}
+
+// Unlock every shard locked by lockAll
+func (c *TransparentCache[V]) unlockAll() {
+	for _, s := range c.shards {
+		s.Unlock()
+	}
+}
+
+// Read lock every shard, to get a consistent view of the whole cache
+func (c *TransparentCache[V]) rlockAll() {
+	for _, s := range c.shards {
+		s.RLock()
+	}
+}
+
+// Unlock every shard locked by rlockAll
+func (c *TransparentCache[V]) runlockAll() {
+	for _, s := range c.shards {
+		s.RUnlock()
+	}
+}
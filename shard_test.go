@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Check that the number of shards is rounded up to a power of two
+func TestWithShards_RoundsUpToPowerOfTwo(t *testing.T) {
+	cases := map[int]int{0: DefaultShards, 1: 1, 3: 4, 16: 16, 17: 32}
+	for count, expected := range cases {
+		cache := NewTransparentCache(&mockPriceService{}, WithShards(count))
+		assertInt(t, expected, len(cache.shards), fmt.Sprintf("wrong number of shards for %d", count))
+	}
+}
+
+// Check that concurrent writes spread over the shards never let the cache grow past maxEntries
+func TestShards_MaxEntriesAcrossShards(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{}, WithShards(8), WithMaxEntries(10))
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				cache.Set(fmt.Sprintf("p%d-%d", w, i), float64(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+	cached := 0
+	for _, s := range cache.shards {
+		cached += len(s.prices)
+	}
+	assertInt(t, 10, cached, "wrong number of cached prices")
+	assertInt(t, 10, int(cache.entries.Load()), "wrong number of counted entries")
+	assertInt(t, 790, int(cache.Stats().Evictions), "wrong number of evictions")
+}
+
+// Check that invalidating everything empties every shard
+func TestShards_InvalidateAll(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{}, WithShards(4), WithMaxEntries(100))
+	for i := 0; i < 20; i++ {
+		cache.Set(fmt.Sprintf("p%d", i), float64(i))
+	}
+	cache.InvalidateAll()
+	for _, s := range cache.shards {
+		assertInt(t, 0, len(s.prices), "shard not emptied")
+	}
+	assertInt(t, 0, int(cache.entries.Load()), "wrong number of counted entries")
+	assertInt(t, 20, int(cache.Stats().Evictions), "wrong number of evictions")
+	cache.Set("p1", 5)
+	price, _, ok := cache.Peek("p1")
+	if !ok {
+		t.Fatal("price not cached after invalidating everything")
+	}
+	assertFloat(t, 5, price, "wrong price cached")
+}
+
+// Check that the shard of each item is picked by the custom hasher, by the low bits of its hash
+func TestWithShardHasher_PicksShardsByTheHash(t *testing.T) {
+	shardOf := map[string]uint64{"a-1": 0, "a-2": 1, "a-3": 2, "a-4": 3, "b-5": 1, "b-6": 3}
+	hasher := func(key string) uint64 {
+		// high bits set so only the low bits may pick the shard
+		return 0xf0<<56 | shardOf[key] + 4
+	}
+	cache := NewTransparentCache(&mockPriceService{}, WithShards(4), WithShardHasher(hasher), WithDebugInvariants())
+	for itemCode := range shardOf {
+		cache.Set(itemCode, 5)
+	}
+	for itemCode, expected := range shardOf {
+		if _, ok := cache.shards[expected].prices[itemCode]; !ok {
+			t.Errorf("[%v] expected in shard %d", itemCode, expected)
+		}
+	}
+	sizes := make([]int, len(cache.shards))
+	for i, s := range cache.shards {
+		sizes[i] = len(s.prices)
+	}
+	if fmt.Sprint(sizes) != "[1 2 1 2]" {
+		t.Errorf("expected shard sizes [1 2 1 2], got %v", sizes)
+	}
+	price, _, ok := cache.Peek("b-6")
+	if !ok {
+		t.Fatal("price not found with the custom hasher")
+	}
+	assertFloat(t, 5, price, "wrong price cached")
+}
+
+// Check that the default hasher spreads codes sharing a long prefix evenly over the shards
+func TestDefaultShardHasher_SpreadsCommonPrefixes(t *testing.T) {
+	cache := NewTransparentCache(&mockPriceService{}, WithShards(16), WithShardHasher(nil))
+	for i := 0; i < 1600; i++ {
+		cache.Set(fmt.Sprintf("STORE-0001-AISLE-07-SKU-%06d", i*16), 5)
+	}
+	for i, s := range cache.shards {
+		if len(s.prices) < 50 || len(s.prices) > 150 {
+			t.Errorf("shard %d has %d of the 1600 items", i, len(s.prices))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// call is a fetch in flight for one item code, shared by every caller waiting on it
+type call[V any] struct {
+	done    chan struct{}
+	price   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup deduplicates concurrent fetches so there is a single in flight call per item code
+type flightGroup[V any] struct {
+	sync.Mutex
+	calls map[string]*call[V]
+}
+
+// Run fn once for all the concurrent callers asking for the same item code
+// fn gets a context that is only cancelled once every waiting caller has given up
+func (g *flightGroup[V]) do(ctx context.Context, itemCode string, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[V]{}
+	}
+	c, ok := g.calls[itemCode]
+	if !ok {
+		c = g.startCall(context.WithoutCancel(ctx), itemCode, fn)
+	}
+	c.waiters++
+	g.Unlock()
+
+	select {
+	case <-c.done:
+		return c.price, c.err
+	case <-ctx.Done():
+		g.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody is waiting anymore, later callers must start a new call
+			c.cancel()
+			g.forget(itemCode, c)
+		}
+		g.Unlock()
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// Start fn in the background unless there is already a call in flight for the item code
+// Nobody waits on the call, it is cancelled with ctx, it returns whether a new call was started
+func (g *flightGroup[V]) start(ctx context.Context, itemCode string, fn func(ctx context.Context) (V, error)) bool {
+	g.Lock()
+	defer g.Unlock()
+	if g.calls == nil {
+		g.calls = map[string]*call[V]{}
+	}
+	if _, ok := g.calls[itemCode]; ok {
+		return false
+	}
+	g.startCall(ctx, itemCode, fn)
+	return true
+}
+
+// Register and run a new call with a context derived from ctx, the lock must be held
+func (g *flightGroup[V]) startCall(ctx context.Context, itemCode string, fn func(ctx context.Context) (V, error)) *call[V] {
+	callCtx, cancel := context.WithCancel(ctx)
+	c := &call[V]{done: make(chan struct{}), cancel: cancel}
+	g.calls[itemCode] = c
+	go g.run(callCtx, itemCode, c, fn)
+	return c
+}
+
+// Run the shared call and release its waiters
+func (g *flightGroup[V]) run(ctx context.Context, itemCode string, c *call[V], fn func(ctx context.Context) (V, error)) {
+	defer c.cancel()
+	c.price, c.err = fn(ctx)
+	g.Lock()
+	g.forget(itemCode, c)
+	g.Unlock()
+	close(c.done)
+}
+
+// Remove the call from the group unless it was already replaced, the lock must be held
+func (g *flightGroup[V]) forget(itemCode string, c *call[V]) {
+	if g.calls[itemCode] == c {
+		delete(g.calls, itemCode)
+	}
+}
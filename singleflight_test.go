@@ -0,0 +1,28 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Check that concurrent requests for the same cold item share a single service call
+func TestGetPriceFor_DeduplicatesInFlightCalls(t *testing.T) {
+	mockService := &mockPriceService{
+		callDelay: 100 * time.Millisecond,
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute))
+	var w sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+		}()
+	}
+	w.Wait()
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
@@ -0,0 +1,17 @@
+package main
+
+// DefaultEntrySize is the estimated size in bytes of a cached price, unless WithSizeEstimator tells otherwise
+const DefaultEntrySize int64 = 64
+
+// Get the estimated size of a cached price
+func (c *TransparentCache[V]) sizeOf(itemCode string, price V) int64 {
+	if c.sizeEstimator == nil {
+		return DefaultEntrySize
+	}
+	return c.sizeEstimator(itemCode, price)
+}
+
+// Tell whether there are more prices than maxEntries or more estimated bytes than maxBytes
+func (c *TransparentCache[V]) overflowing() bool {
+	return (c.maxEntries > 0 && c.entries.Load() > int64(c.maxEntries)) || (c.maxBytes > 0 && c.bytes.Load() > c.maxBytes)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotEntry is a cached price as it is written by Snapshot
+type snapshotEntry[V any] struct {
+	ItemCode string        `json:"itemCode"`
+	Currency string        `json:"currency,omitempty"` // only for prices in another currency than the default one
+	Price    V             `json:"price"`
+	CachedAt time.Time     `json:"cachedAt"`
+	TTL      time.Duration `json:"ttl,omitempty"` // only for items set with their own TTL
+}
+
+// Snapshot serializes the cached prices, and when they were got, to JSON
+// Every shard is locked while taking it, so it is a consistent view of the cache
+func (c *TransparentCache[V]) Snapshot() ([]byte, error) {
+	c.rlockAll()
+	entries := make([]snapshotEntry[V], 0, c.entries.Load())
+	for _, s := range c.shards {
+		for itemCode, price := range s.prices {
+			cachedAt, _ := s.cachedAt(itemCode)
+			// the raw price is kept, so it is derived again when restored
+			if raw, ok := s.rawByItem[itemCode]; ok {
+				price = raw
+			}
+			code, currency := splitKey(itemCode)
+			entries = append(entries, snapshotEntry[V]{ItemCode: code, Currency: currency, Price: price, CachedAt: cachedAt,
+				TTL: s.ttlByItem[itemCode]})
+		}
+	}
+	c.runlockAll()
+	return json.Marshal(entries)
+}
+
+// Restore loads the prices of a snapshot back into the cache, keeping when they were got
+// Prices which are already older than maxAge, or their own TTL, are discarded
+func (c *TransparentCache[V]) Restore(data []byte) error {
+	var entries []snapshotEntry[V]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("restoring snapshot : %w", err)
+	}
+	now := c.clock.Now()
+	for _, entry := range entries {
+		maxAge := c.MaxAge()
+		if entry.TTL > 0 {
+			maxAge = entry.TTL
+		}
+		if !isFresh(entry.CachedAt, maxAge, now) || isEmptyItemCode(entry.ItemCode) {
+			continue
+		}
+		key := joinKey(entry.ItemCode, entry.Currency)
+		c.currencies.add(entry.Currency)
+		s := c.shardFor(key)
+		s.Lock()
+		c.storeDerivedAt(s, key, c.scale(entry.Price), entry.CachedAt)
+		if entry.TTL > 0 {
+			s.ttlByItem[key] = entry.TTL
+		}
+		s.Unlock()
+	}
+	c.emitEvictions(c.evictOverflow())
+	return nil
+}
+
+// SaveSnapshot writes the snapshot of the cache to the file at path, replacing it
+// The snapshot is written to a temporary file first, so the file at path is never left half written
+func (c *TransparentCache[V]) SaveSnapshot(path string) error {
+	data, err := c.Snapshot()
+	if err != nil {
+		return fmt.Errorf("saving snapshot : %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("saving snapshot : %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving snapshot : %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving snapshot : %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("saving snapshot : %w", err)
+	}
+	return nil
+}
+
+// Restore the snapshot of the file at path, a missing file is an empty snapshot
+func (c *TransparentCache[V]) restoreFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("restoring snapshot : %w", err)
+	}
+	return c.Restore(data)
+}
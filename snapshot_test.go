@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Check that the prices of a snapshot are restored into another cache, with when they were got
+func TestSnapshot_RoundTrip(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithClock(clock), WithMaxAge(time.Minute))
+	cache.Set("p1", 5)
+	clock.Advance(10 * time.Second)
+	cache.Set("p2", 7)
+	data, err := cache.Snapshot()
+	if err != nil {
+		t.Fatal("error taking snapshot", err)
+	}
+
+	mockService := &mockPriceService{}
+	restored := NewTransparentCache(mockService, WithClock(clock), WithMaxAge(time.Minute))
+	if err := restored.Restore(data); err != nil {
+		t.Fatal("error restoring snapshot", err)
+	}
+	assertFloat(t, 5, getPriceWithNoErr(t, restored, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, restored, "p2"), "wrong price returned")
+	assertInt(t, 0, mockService.getNumCalls(), "wrong number of service calls")
+	expiresAt, _ := restored.ExpiresAt("p1")
+	assertTime(t, clock.Now().Add(50*time.Second), expiresAt, "wrong expiration for p1")
+}
+
+// Check that prices already older than maxAge are not restored
+func TestSnapshot_RestoreDropsExpired(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTransparentCache(&mockPriceService{}, WithClock(clock), WithMaxAge(time.Minute))
+	cache.Set("p1", 5)
+	clock.Advance(30 * time.Second)
+	cache.Set("p2", 7)
+	data, err := cache.Snapshot()
+	if err != nil {
+		t.Fatal("error taking snapshot", err)
+	}
+	clock.Advance(45 * time.Second)
+
+	restored := NewTransparentCache(&mockPriceService{}, WithClock(clock), WithMaxAge(time.Minute))
+	if err := restored.Restore(data); err != nil {
+		t.Fatal("error restoring snapshot", err)
+	}
+	if _, _, ok := restored.Peek("p1"); ok {
+		t.Error("expired price for p1 restored")
+	}
+	if _, fresh, ok := restored.Peek("p2"); !ok || !fresh {
+		t.Error("fresh price for p2 not restored")
+	}
+	if err := restored.Restore([]byte("not json")); err == nil {
+		t.Error("expected error restoring an invalid snapshot")
+	}
+}
+
+// Check that a cache saved to a snapshot file starts warm from it
+func TestWithSnapshotFile_StartsWarm(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	clock := newFakeClock()
+	// a missing file is an empty snapshot
+	cache := NewTransparentCache(&mockPriceService{}, WithClock(clock), WithMaxAge(time.Minute),
+		WithSnapshotFile(path), WithSnapshotOnClose())
+	assertInt(t, 0, cache.Len(), "wrong number of restored prices")
+	cache.Set("p1", 5)
+	cache.Set("p2", 7)
+	if err := cache.Close(); err != nil {
+		t.Fatal("error saving snapshot", err)
+	}
+
+	mockService := &mockPriceService{}
+	restored := NewTransparentCache(mockService, WithClock(clock), WithMaxAge(time.Minute), WithSnapshotFile(path))
+	assertFloat(t, 5, getPriceWithNoErr(t, restored, "p1"), "wrong price returned")
+	assertFloat(t, 7, getPriceWithNoErr(t, restored, "p2"), "wrong price returned")
+	assertInt(t, 0, mockService.getNumCalls(), "wrong number of service calls")
+
+	restored.Set("p3", 9)
+	if err := restored.SaveSnapshot(path); err != nil {
+		t.Fatal("error saving snapshot", err)
+	}
+	again := NewTransparentCache(mockService, WithClock(clock), WithMaxAge(time.Minute), WithSnapshotFile(path))
+	assertInt(t, 3, again.Len(), "wrong number of restored prices")
+}
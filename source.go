@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Source is where a price returned by GetPriceForWithSource came from
+type Source int
+
+const (
+	SourceMiss     Source = iota // the price was got from the service, or failed to be
+	SourceHit                    // a fresh cached price, or a cached error, was returned
+	SourceStale                  // an expired price was returned, while being refreshed or as the service was unavailable
+	SourceFallback               // the price was got from the fallback service as the actual one failed
+	SourceStore                  // the price was loaded from the store of WithStore, as saved by this or another cache
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceMiss:
+		return "miss"
+	case SourceHit:
+		return "hit"
+	case SourceStale:
+		return "stale"
+	case SourceFallback:
+		return "fallback"
+	case SourceStore:
+		return "store"
+	}
+	return "unknown"
+}
+
+// sourced is a price along with where it came from, as shared by the callers waiting on the same fetch
+type sourced[V any] struct {
+	price  V
+	source Source
+}
+
+// GetPriceForWithSource gets the price for the item as GetPriceFor does, telling where it came from
+func (c *TransparentCache[V]) GetPriceForWithSource(itemCode string) (V, Source, error) {
+	itemCode = c.key(itemCode)
+	return c.getPrice(context.Background(), itemCode)
+}
+
+// GetPriceForWithTime gets the price for the item as GetPriceFor does, along with when it was got from the service
+// or set, which on a hit is when the cached price was got rather than now
+// The time of demoted prices is only kept to the second, and a price which is not cached, like the ones got
+// while bypassing the cache, was got now
+func (c *TransparentCache[V]) GetPriceForWithTime(itemCode string) (V, time.Time, error) {
+	itemCode = c.key(itemCode)
+	price, _, err := c.getPrice(context.Background(), itemCode)
+	if err != nil {
+		return price, time.Time{}, err
+	}
+	if c.bypass.Load() {
+		return price, c.clock.Now(), nil
+	}
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	// the price is read again along with its time, in case it was replaced meanwhile
+	if cachedAt, ok := s.cachedAt(itemCode); ok {
+		return s.prices[itemCode], cachedAt, nil
+	}
+	return price, c.clock.Now(), nil
+}
+
+// GetPriceForChanged gets the price for the item as GetPriceFor does, telling whether it changed
+// It only changed when this call got it from the service and it differs from the price cached before, if any,
+// or its version does, so it never changed when it is answered from the cache or loaded from the store
+func (c *TransparentCache[V]) GetPriceForChanged(itemCode string) (V, bool, error) {
+	itemCode = c.key(itemCode)
+	s := c.shardFor(itemCode)
+	s.RLock()
+	previous, cached := s.prices[itemCode]
+	previousVersion := s.versionByItem[itemCode]
+	s.RUnlock()
+	price, source, err := c.getPrice(context.Background(), itemCode)
+	if err != nil || (source != SourceMiss && source != SourceFallback) {
+		return price, false, err
+	}
+	s.RLock()
+	version := s.versionByItem[itemCode]
+	s.RUnlock()
+	return price, !cached || !reflect.DeepEqual(previous, price) || version != previousVersion, nil
+}
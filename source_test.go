@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Check that every way of answering a price is told apart
+func TestGetPriceForWithSource_TellsWhereThePriceCameFrom(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 0, err: fmt.Errorf("primary down")},
+		},
+	}
+	fallback := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p2": {price: 7, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStaleWhileRevalidate(time.Minute),
+		WithFallbackService(fallback))
+	assertSource := func(expectedPrice float64, expectedSource Source, itemCode string) {
+		t.Helper()
+		price, source, err := cache.GetPriceForWithSource(itemCode)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", itemCode, err)
+		}
+		assertFloat(t, expectedPrice, price, "wrong price returned")
+		if source != expectedSource {
+			t.Errorf("wrong source for %v, expected : %v, got : %v", itemCode, expectedSource, source)
+		}
+	}
+	assertSource(5, SourceMiss, "p1")
+	assertSource(5, SourceHit, "p1")
+	assertSource(7, SourceFallback, "p2")
+	clock.Advance(time.Minute)
+	assertSource(5, SourceStale, "p1")
+}
+
+func TestGetPriceForChanged_TellsWhetherTheFetchChangedThePrice(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	assertChanged := func(expectedPrice float64, expectedChanged bool, msg string) {
+		t.Helper()
+		price, changed, err := cache.GetPriceForChanged("p1")
+		if err != nil || price != expectedPrice || changed != expectedChanged {
+			t.Errorf("%v: expected %v changed %v, got %v changed %v, error %v", msg, expectedPrice, expectedChanged, price, changed, err)
+		}
+	}
+	assertChanged(5, true, "first fetch")
+	assertChanged(5, false, "cache hit")
+	clock.Advance(time.Minute)
+	assertChanged(5, false, "refetch of the same price")
+	mockService.setResult("p1", mockResult{price: 6})
+	clock.Advance(time.Minute)
+	assertChanged(6, true, "refetch of a different price")
+	assertChanged(6, false, "cache hit")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a price loaded from the store is told apart, and never changed as the service was not called
+func TestGetPriceForChanged_LoadedFromTheStore(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore[float64]()
+	store.Save("p1", 5, clock.Now())
+	store.Save("p2", 7, clock.Now())
+	mockService := &mockPriceService{}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store))
+	defer cache.Close()
+	if price, changed, err := cache.GetPriceForChanged("p1"); err != nil || price != 5 || changed {
+		t.Errorf("expected 5 not changed, got %v changed %v, error %v", price, changed, err)
+	}
+	if _, source, _ := cache.GetPriceForWithSource("p2"); source != SourceStore {
+		t.Errorf("wrong source, expected : %v, got : %v", SourceStore, source)
+	}
+	assertInt(t, 0, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that a new version counts as a change, even for the same price
+func TestGetPriceForChanged_NewVersion(t *testing.T) {
+	mockService := &versionedPriceService{
+		mockPriceService: mockPriceService{
+			mockResults: map[string]mockResult{
+				"p1": {price: 5, err: nil},
+			},
+		},
+		version: "v1",
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	for i, expected := range []bool{true, false, true} {
+		if i == 2 {
+			mockService.setVersion("v2")
+		}
+		if _, changed, err := cache.GetPriceForChanged("p1"); err != nil || changed != expected {
+			t.Errorf("[%d] expected changed %v, got %v, error %v", i, expected, changed, err)
+		}
+		clock.Advance(time.Minute)
+	}
+}
+
+func TestGetPriceForWithTime_TellsWhenThePriceWasGot(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	fetchedAt := clock.Now()
+	for i := 0; i < 3; i++ {
+		price, cachedAt, err := cache.GetPriceForWithTime("p1")
+		if err != nil || price != 5 || !cachedAt.Equal(fetchedAt) {
+			t.Errorf("expected 5 got at %v, got %v at %v, error %v", fetchedAt, price, cachedAt, err)
+		}
+		clock.Advance(20 * time.Second)
+	}
+	// once expired the price is got again, now
+	_, cachedAt, _ := cache.GetPriceForWithTime("p1")
+	if !cachedAt.Equal(clock.Now()) {
+		t.Errorf("expected the refreshed price to be got at %v, got %v", clock.Now(), cachedAt)
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
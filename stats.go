@@ -0,0 +1,44 @@
+package main
+
+import "sync/atomic"
+
+// Stats are the counters of how effective the cache has been
+type Stats struct {
+	Hits      uint64 // lookups answered from the cache, with a price or a cached error
+	Misses    uint64 // lookups that had to go to the service
+	Evictions uint64 // cached prices removed from the cache
+	// background refreshes not done because the refresh queue was full
+	DroppedRefreshes uint64
+}
+
+// stats holds the counters, they are updated atomically so they can be read at any time
+type stats struct {
+	hits             atomic.Uint64
+	misses           atomic.Uint64
+	evictions        atomic.Uint64
+	droppedRefreshes atomic.Uint64
+}
+
+// Stats returns the current value of the counters
+func (c *TransparentCache[V]) Stats() Stats {
+	return Stats{
+		Hits:             c.stats.hits.Load(),
+		Misses:           c.stats.misses.Load(),
+		Evictions:        c.stats.evictions.Load(),
+		DroppedRefreshes: c.stats.droppedRefreshes.Load(),
+	}
+}
+
+// ResetStats sets every counter back to zero
+func (c *TransparentCache[V]) ResetStats() {
+	c.stats.hits.Store(0)
+	c.stats.misses.Store(0)
+	c.stats.evictions.Store(0)
+	c.stats.droppedRefreshes.Store(0)
+}
+
+// ActiveFetches returns how many calls to the service are in flight right now, whatever they were made for
+// Calls the cache gave up on, say when their context was done, count until the service returns
+func (c *TransparentCache[V]) ActiveFetches() int {
+	return int(c.activeFetches.Load())
+}
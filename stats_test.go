@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func assertStats(t *testing.T, expected Stats, actual Stats, msg string) {
+	assertInt(t, int(expected.Hits), int(actual.Hits), msg+" hits")
+	assertInt(t, int(expected.Misses), int(actual.Misses), msg+" misses")
+	assertInt(t, int(expected.Evictions), int(actual.Evictions), msg+" evictions")
+}
+
+// Check that hits, misses and evictions are counted for fresh, stale and cold lookups
+func TestStats_CountsLookups(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+	}
+	maxAge := 50 * time.Millisecond
+	cache := NewTransparentCache(mockService, WithMaxAge(maxAge))
+	getPriceWithNoErr(t, cache, "p1") // cold
+	getPriceWithNoErr(t, cache, "p1") // fresh
+	getPriceWithNoErr(t, cache, "p1") // fresh
+	assertStats(t, Stats{Hits: 2, Misses: 1}, cache.Stats(), "wrong stats after fresh lookups")
+	time.Sleep(maxAge)
+	getPriceWithNoErr(t, cache, "p1") // stale
+	getPriceWithNoErr(t, cache, "p2") // cold
+	getPriceWithNoErr(t, cache, "p2") // fresh
+	assertStats(t, Stats{Hits: 3, Misses: 3}, cache.Stats(), "wrong stats after stale lookups")
+	cache.Invalidate("p1")
+	cache.Invalidate("p1")
+	assertStats(t, Stats{Hits: 3, Misses: 3, Evictions: 1}, cache.Stats(), "wrong stats after invalidation")
+	cache.ResetStats()
+	assertStats(t, Stats{}, cache.Stats(), "wrong stats after reset")
+}
+
+// Check that the service calls in flight are counted until they return
+func TestActiveFetches_CountsServiceCallsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	service := priceFunc(func(itemCode string) (float64, error) {
+		<-release
+		return 5, nil
+	})
+	cache := NewTransparentCache(service, WithMaxConcurrency(3))
+	assertInt(t, 0, cache.ActiveFetches(), "wrong number of active fetches before the batch")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := cache.GetPricesFor("p1", "p2", "p3", "p4", "p5"); err != nil {
+			t.Error("unexpected error", err)
+		}
+	}()
+	waitFor(t, func() bool { return cache.ActiveFetches() == 3 }, "the workers didn't call the service")
+	close(release)
+	<-done
+	assertInt(t, 0, cache.ActiveFetches(), "wrong number of active fetches after the batch")
+}
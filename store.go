@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Store persists the prices got from the service, so they survive restarts of the cache
+// A store shared by several caches, such as one backed by Redis, is a second tier over which the caches of every
+// instance sit: a price got from the service by one of them is then found in the store by the others
+type Store[V any] interface {
+	// Load gets the price saved for the key and when it was got, ok is false when there is none
+	Load(key string) (price V, at time.Time, ok bool, err error)
+	// Save persists the price for the key, got at the given time
+	Save(key string, price V, at time.Time) error
+}
+
+// MemoryStore is a Store keeping the prices in memory, mostly useful for tests
+type MemoryStore[V any] struct {
+	mu      sync.Mutex
+	entries map[string]storedPrice[V]
+}
+
+// storedPrice is a price saved in a MemoryStore
+type storedPrice[V any] struct {
+	price V
+	at    time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore[V any]() *MemoryStore[V] {
+	return &MemoryStore[V]{entries: map[string]storedPrice[V]{}}
+}
+
+func (m *MemoryStore[V]) Load(key string) (V, time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	return entry.price, entry.at, ok, nil
+}
+
+func (m *MemoryStore[V]) Save(key string, price V, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = storedPrice[V]{price: price, at: at}
+	return nil
+}
+
+// Get a price from the store which is still fresh for the item, caching it with the time it was got
+// Freshness is judged as for the cached prices, by the TTL of the item, its tier or else maxAge
+// Errors loading are not fatal, the price is then got from the service
+func (c *TransparentCache[V]) loadFromStore(itemCode string) (V, bool) {
+	var zero V
+	if c.store == nil {
+		return zero, false
+	}
+	price, at, ok, err := c.store.Load(itemCode)
+	if err != nil || !ok {
+		return zero, false
+	}
+	price = c.scale(price)
+	s := c.shardFor(itemCode)
+	s.Lock()
+	if !isFresh(at, c.maxAgeFor(s, itemCode), c.clock.Now()) {
+		s.Unlock()
+		return zero, false
+	}
+	price, _, _ = c.storeDerivedAt(s, itemCode, price, at)
+	s.Unlock()
+	c.emitEvictions(c.evictOverflow())
+	return price, true
+}
+
+// Save the prices got from the service or set into the store in the background, the cache doesn't wait for it
+// The prices are saved raw, before the transform, so every cache loading them derives its own
+func (c *TransparentCache[V]) saveToStore(prices map[string]V) {
+	if c.store == nil || len(prices) == 0 {
+		return
+	}
+	at := c.clock.Now()
+	c.goBackground(func() {
+		for itemCode, price := range prices {
+			// a failed save only means the price is got from the service again after a restart
+			_ = c.store.Save(itemCode, price, at)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStore is a MemoryStore recording the keys loaded and saved
+type recordingStore struct {
+	*MemoryStore[float64]
+	mu     sync.Mutex
+	loaded []string
+	saved  []string
+}
+
+func (r *recordingStore) Load(key string) (float64, time.Time, bool, error) {
+	r.mu.Lock()
+	r.loaded = append(r.loaded, key)
+	r.mu.Unlock()
+	return r.MemoryStore.Load(key)
+}
+
+func (r *recordingStore) Save(key string, price float64, at time.Time) error {
+	r.mu.Lock()
+	r.saved = append(r.saved, key)
+	r.mu.Unlock()
+	return r.MemoryStore.Save(key, price, at)
+}
+
+func (r *recordingStore) get() ([]string, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.loaded...), append([]string(nil), r.saved...)
+}
+
+// Check that the store is consulted on a miss before the service, and prices got from the service are saved
+func TestWithStore_ReadsAndWritesThrough(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 50, err: nil},
+			"p2": {price: 70, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	store := &recordingStore{MemoryStore: NewMemoryStore[float64]()}
+	store.MemoryStore.Save("p1", 5, clock.Now().Add(-30*time.Second))
+	store.MemoryStore.Save("p2", 7, clock.Now().Add(-2*time.Minute))
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store))
+	defer cache.Close()
+	// p1 is fresh in the store, p2 is too old so it is got from the service
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertFloat(t, 70, getPriceWithNoErr(t, cache, "p2"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	waitFor(t, func() bool { _, saved := store.get(); return len(saved) == 1 }, "price not saved")
+	loaded, saved := store.get()
+	assertStrings(t, []string{"p1", "p2"}, loaded, "wrong keys loaded")
+	assertStrings(t, []string{"p2"}, saved, "wrong keys saved")
+	price, at, ok, _ := store.MemoryStore.Load("p2")
+	if !ok {
+		t.Fatal("expected p2 to be saved")
+	}
+	assertFloat(t, 70, price, "wrong price saved")
+	assertTime(t, clock.Now(), at, "wrong time saved")
+	// the price loaded from the store keeps its age
+	clock.Advance(30 * time.Second)
+	assertFloat(t, 50, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+}
+
+// Check that caches of different instances sharing a store, as a second tier, don't call the service for the same price
+func TestWithStore_SharedAsSecondTier(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	store := NewMemoryStore[float64]()
+	first := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store))
+	defer first.Close()
+	second := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store))
+	defer second.Close()
+	assertFloat(t, 5, getPriceWithNoErr(t, first, "p1"), "wrong price returned")
+	waitFor(t, func() bool { _, _, ok, _ := store.Load("p1"); return ok }, "price not saved")
+	assertFloat(t, 5, getPriceWithNoErr(t, second, "p1"), "wrong price returned from the second tier")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	// the price found in the second tier is now cached locally too
+	if _, fresh, ok := second.Peek("p1"); !ok || !fresh {
+		t.Error("price from the second tier not cached locally")
+	}
+}
+
+// Check that the prices set reach the store too, and that the stored prices are judged by the TTL of their tier
+func TestWithStore_SavesSetPricesAndHonorsTheTiers(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore[float64]()
+	tiers := func(itemCode string) time.Duration {
+		if itemCode == "volatile" {
+			return 10 * time.Second
+		}
+		return 0
+	}
+	first := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store))
+	defer first.Close()
+	first.Set("p1", 5)
+	first.SetMany(map[string]float64{"p2": 7})
+	first.SetWithTTL("volatile", 9, time.Hour)
+	waitFor(t, func() bool {
+		for _, itemCode := range []string{"p1", "p2", "volatile"} {
+			if _, _, ok, _ := store.Load(itemCode); !ok {
+				return false
+			}
+		}
+		return true
+	}, "set prices not saved")
+
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"volatile": {price: 11, err: nil},
+		},
+	}
+	second := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store),
+		WithTTLClassifier(tiers))
+	defer second.Close()
+	clock.Advance(30 * time.Second)
+	assertFloatsInOrder(t, []float64{5, 7}, getPricesWithNoErr(t, second, "p1", "p2"), "wrong prices returned")
+	// older than its tier allows, so it is got from the service
+	assertFloat(t, 11, getPriceWithNoErr(t, second, "volatile"), "wrong price returned")
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+}
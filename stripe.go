@@ -0,0 +1,23 @@
+package main
+
+import "math/bits"
+
+// DefaultLockStripes is the number of stripes the fetches in flight are split into unless told otherwise
+const DefaultLockStripes = 256
+
+// Create the stripes of the fetches in flight, their number is rounded up to a power of two as the shards
+func newStripes[V any](count int) []flightGroup[sourced[V]] {
+	size := 1
+	for size < count {
+		size *= 2
+	}
+	return make([]flightGroup[sourced[V]], size)
+}
+
+// Get the stripe tracking the fetch in flight for the item, each stripe with a lock of its own
+// The stripe is picked by the bits of the hash above the ones picking the shard, so the items of a shard
+// are spread over the stripes whatever the number of shards is
+func (c *TransparentCache[V]) flightsFor(itemCode string) *flightGroup[sourced[V]] {
+	hash := c.shardHasher(itemCode) >> bits.TrailingZeros(uint(len(c.shards)))
+	return &c.stripes[hash&uint64(len(c.stripes)-1)]
+}
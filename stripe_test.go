@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Check that the number of stripes is rounded up to a power of two, whatever the number of shards
+func TestWithLockStripes_RoundsUpToPowerOfTwo(t *testing.T) {
+	cases := map[int]int{0: DefaultLockStripes, 1: 1, 3: 4, 64: 64, 65: 128}
+	for count, expected := range cases {
+		cache := NewTransparentCache(&mockPriceService{}, WithShards(4), WithLockStripes(count))
+		assertInt(t, expected, len(cache.stripes), fmt.Sprintf("wrong number of stripes for %d", count))
+	}
+}
+
+// Check that the items of a shard are spread over the stripes, by the bits of the hash above the shard ones
+func TestFlightsFor_SpreadsTheItemsOfAShard(t *testing.T) {
+	hashes := map[string]uint64{"a": 0b00_00, "b": 0b01_00, "c": 0b10_00, "d": 0b11_00, "e": 0b01_01}
+	hasher := func(key string) uint64 { return hashes[key] }
+	cache := NewTransparentCache(&mockPriceService{}, WithShards(4), WithLockStripes(4), WithShardHasher(hasher))
+	for itemCode, stripe := range map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "e": 1} {
+		if cache.flightsFor(itemCode) != &cache.stripes[stripe] {
+			t.Errorf("[%v] expected in stripe %d", itemCode, stripe)
+		}
+	}
+	// a to d share a shard, e doesn't share it with b even if they share a stripe
+	for _, itemCode := range []string{"b", "c", "d"} {
+		if cache.shardFor(itemCode) != cache.shardFor("a") {
+			t.Errorf("[%v] expected in the shard of a", itemCode)
+		}
+	}
+	if cache.shardFor("e") == cache.shardFor("b") {
+		t.Error("expected e in another shard than b")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+// SubscriberBuffer is how many updates a subscriber can be behind before updates for it are dropped
+const SubscriberBuffer = 64
+
+// PriceUpdate tells a subscriber that the cached price for an item changed
+type PriceUpdate[V any] struct {
+	ItemCode string
+	Old, New V
+}
+
+// subscribers are the channels updates are published to
+type subscribers[V any] struct {
+	sync.Mutex
+	channels map[<-chan PriceUpdate[V]]chan PriceUpdate[V]
+}
+
+// Subscribe returns a channel receiving an update every time a cached price is replaced by a different one,
+// by a call to the service, a background refresh or a Set
+// Updates are never waited for, they are dropped when the subscriber is SubscriberBuffer updates behind
+// Unsubscribe must be called when done, the channel is closed then, or when the cache is closed
+func (c *TransparentCache[V]) Subscribe() <-chan PriceUpdate[V] {
+	ch := make(chan PriceUpdate[V], SubscriberBuffer)
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	if c.isClosed() {
+		close(ch)
+		return ch
+	}
+	if c.subs.channels == nil {
+		c.subs.channels = map[<-chan PriceUpdate[V]]chan PriceUpdate[V]{}
+	}
+	c.subs.channels[ch] = ch
+	return ch
+}
+
+// Unsubscribe stops sending updates to the channel returned by Subscribe, and closes it
+func (c *TransparentCache[V]) Unsubscribe(updates <-chan PriceUpdate[V]) {
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	if ch, ok := c.subs.channels[updates]; ok {
+		delete(c.subs.channels, updates)
+		close(ch)
+	}
+}
+
+// Close every subscription
+func (c *TransparentCache[V]) unsubscribeAll() {
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	for updates, ch := range c.subs.channels {
+		delete(c.subs.channels, updates)
+		close(ch)
+	}
+}
+
+// Tell whether anybody is subscribed
+func (c *TransparentCache[V]) hasSubscribers() bool {
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	return len(c.subs.channels) > 0
+}
+
+// Add an update to updates when the price replaced a different one, and somebody is subscribed
+func (c *TransparentCache[V]) updates(updates []PriceUpdate[V], itemCode string, old V, existed bool, price V) []PriceUpdate[V] {
+	if !existed || !c.hasSubscribers() || reflect.DeepEqual(old, price) {
+		return updates
+	}
+	return append(updates, PriceUpdate[V]{ItemCode: itemCode, Old: old, New: price})
+}
+
+// Send the updates to every subscriber that has room for them
+func (c *TransparentCache[V]) publish(updates []PriceUpdate[V]) {
+	if len(updates) == 0 {
+		return
+	}
+	c.subs.Lock()
+	defer c.subs.Unlock()
+	for _, ch := range c.subs.channels {
+		for _, update := range updates {
+			select {
+			case ch <- update:
+			default:
+			}
+		}
+	}
+}
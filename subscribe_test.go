@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Check that subscribers get an update only when a cached price changes value
+func TestSubscribe_PublishesChangesOnly(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	updates := cache.Subscribe()
+	other := cache.Subscribe()
+	getPriceWithNoErr(t, cache, "p1")
+	// refreshed to the same price
+	clock.Advance(time.Minute)
+	getPriceWithNoErr(t, cache, "p1")
+	// refreshed to a different price
+	mockService.setResult("p1", mockResult{price: 7})
+	clock.Advance(time.Minute)
+	getPriceWithNoErr(t, cache, "p1")
+	cache.Set("p1", 7)
+	cache.Set("p1", 9)
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+
+	for _, ch := range []<-chan PriceUpdate[float64]{updates, other} {
+		for _, expected := range []PriceUpdate[float64]{{ItemCode: "p1", Old: 5, New: 7}, {ItemCode: "p1", Old: 7, New: 9}} {
+			select {
+			case update := <-ch:
+				if update != expected {
+					t.Errorf("wrong update, expected : %v, got : %v", expected, update)
+				}
+			default:
+				t.Fatal("missing update", expected)
+			}
+		}
+		select {
+		case update := <-ch:
+			t.Error("unexpected update", update)
+		default:
+		}
+	}
+
+	cache.Unsubscribe(updates)
+	if _, ok := <-updates; ok {
+		t.Error("expected the channel to be closed after unsubscribing")
+	}
+	cache.Set("p1", 11)
+	if update := <-other; update.New != 11 {
+		t.Error("wrong update", update)
+	}
+	cache.Close()
+	if _, ok := <-other; ok {
+		t.Error("expected the channel to be closed after closing the cache")
+	}
+}
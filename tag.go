@@ -0,0 +1,52 @@
+package main
+
+// SetTag tags the cached item, so it can be invalidated along with the other items of the tag
+// An item has a single tag, setting another one replaces it and an empty one removes it
+// Items which are not cached are not tagged, the tag goes away when the item is evicted or invalidated
+// The prices of the item in every currency are tagged
+func (c *TransparentCache[V]) SetTag(itemCode, tag string) {
+	for _, key := range c.currencies.keys(c.key(itemCode)) {
+		c.setTag(key, tag)
+	}
+}
+
+// Tag the cached price under the key, if there is one
+func (c *TransparentCache[V]) setTag(key, tag string) {
+	s := c.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.prices[key]; !ok {
+		return
+	}
+	if tag == "" {
+		delete(s.tagByItem, key)
+		return
+	}
+	s.tagByItem[key] = tag
+}
+
+// InvalidateTag removes at once the cached prices for every item tagged with tag, in every currency
+// as Invalidate does, even the ones cached after the item was tagged
+// It returns how many prices were removed
+func (c *TransparentCache[V]) InvalidateTag(tag string) int {
+	var evicted []string
+	c.lockAll()
+	var itemCodes []string
+	for _, s := range c.shards {
+		for key, itemTag := range s.tagByItem {
+			if itemTag == tag {
+				itemCode, _ := splitKey(key)
+				itemCodes = append(itemCodes, itemCode)
+			}
+		}
+	}
+	for _, itemCode := range itemCodes {
+		for _, key := range c.currencies.keys(itemCode) {
+			evicted = c.invalidateLocked(c.shardFor(key), key, evicted)
+		}
+	}
+	c.unlockAll()
+	c.checkInvariants()
+	c.emitEvictions(evicted)
+	return len(evicted)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The timeouts set for some items, overriding the service timeout
+type itemTimeouts struct {
+	sync.RWMutex
+	byItem map[string]time.Duration
+}
+
+// Get the timeout of the item, if it has its own
+func (t *itemTimeouts) get(itemCode string) (time.Duration, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	timeout, ok := t.byItem[itemCode]
+	return timeout, ok
+}
+
+// SetItemTimeout bounds every service call for the item by timeout, overriding WithServiceTimeout for it
+// A timeout of 0 or less removes it, the item goes back to the service timeout
+// Calls for several items at once are still bounded by the service timeout only
+func (c *TransparentCache[V]) SetItemTimeout(itemCode string, timeout time.Duration) {
+	itemCode = c.key(itemCode)
+	c.itemTimeouts.Lock()
+	defer c.itemTimeouts.Unlock()
+	if timeout <= 0 {
+		delete(c.itemTimeouts.byItem, itemCode)
+		return
+	}
+	if c.itemTimeouts.byItem == nil {
+		c.itemTimeouts.byItem = make(map[string]time.Duration)
+	}
+	c.itemTimeouts.byItem[itemCode] = timeout
+}
+
+// Bound a single service call by the service timeout, if any, besides the deadline of ctx
+func (c *TransparentCache[V]) withServiceTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, c.serviceTimeout)
+}
+
+// Bound a service call for the item by its own timeout, or by the service timeout when it has none
+func (c *TransparentCache[V]) withItemTimeout(ctx context.Context, itemCode string) (context.Context, context.CancelFunc) {
+	if timeout, ok := c.itemTimeouts.get(itemCode); ok {
+		return withTimeout(ctx, timeout)
+	}
+	return c.withServiceTimeout(ctx)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Tell a call that ran out of the service timeout apart from one whose caller gave up
+func timeoutError(ctx, callCtx context.Context, err error) error {
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return ErrServiceTimeout
+	}
+	return err
+}
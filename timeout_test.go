@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Check that a service slower than the timeout fails in time, on every attempt, without caching anything
+func TestWithServiceTimeout_FailsSlowCalls(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+		callDelay: 200 * time.Millisecond,
+	}
+	cache := NewTransparentCache(mockService, WithServiceTimeout(20*time.Millisecond), WithRetry(2, time.Millisecond), WithNegativeTTL(time.Minute))
+	start := time.Now()
+	_, err := cache.GetPriceFor("p1")
+	if !errors.Is(err, ErrServiceTimeout) || !errors.Is(err, ErrServiceFailure) {
+		t.Errorf("expected a service timeout, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("the service timeout was not enforced")
+	}
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	assertInt(t, 0, cache.Len(), "wrong number of cached prices")
+	// the timeout is not remembered, the service is called again right away
+	if _, err := cache.GetPriceFor("p1"); !errors.Is(err, ErrServiceTimeout) {
+		t.Errorf("expected a service timeout, got %v", err)
+	}
+	assertInt(t, 4, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the timeout of an item overrides the service timeout for that item only
+func TestSetItemTimeout_OverridesServiceTimeout(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+			"p2": {price: 7, err: nil},
+		},
+		callDelay: 50 * time.Millisecond,
+	}
+	cache := NewTransparentCache(mockService, WithServiceTimeout(time.Second))
+	cache.SetItemTimeout("p1", 5*time.Millisecond)
+	if _, err := cache.GetPriceFor("p1"); !errors.Is(err, ErrServiceTimeout) {
+		t.Errorf("expected a service timeout, got %v", err)
+	}
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p2"), "wrong price for p2")
+	// without its own timeout the item goes back to the service timeout
+	cache.SetItemTimeout("p1", 0)
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price for p1")
+}
@@ -0,0 +1,42 @@
+package main
+
+import "context"
+
+// Tracer starts spans, it can be backed by OpenTelemetry or any other tracing library
+type Tracer interface {
+	// Start starts a span as child of the one in ctx, returning the context holding the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation being traced
+type Span interface {
+	SetAttribute(key string, value any)
+	End()
+}
+
+// noopSpan is the span used when there is no tracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value any) {}
+
+func (noopSpan) End() {}
+
+// Start a span for the item when there is a tracer
+func (c *TransparentCache[V]) startSpan(ctx context.Context, name string, itemCode string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := c.tracer.Start(ctx, name)
+	if itemCode != "" {
+		span.SetAttribute("item.code", itemCode)
+	}
+	return ctx, span
+}
+
+// End a span around a service call, recording its error if any
+func endServiceSpan(span Span, err error) {
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recordingTracer keeps the spans it started, each one as its name, parent and attributes
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer     *recordingTracer
+	name       string
+	parent     string
+	attributes map[string]any
+	ended      bool
+}
+
+type spanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &recordedSpan{tracer: r, name: name, attributes: map[string]any{}}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+func (r *recordingTracer) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make([]string, 0, len(r.spans))
+	for _, s := range r.spans {
+		spans = append(spans, fmt.Sprintf("%s<%s>%v:%v:%v", s.name, s.parent, s.attributes["item.code"], s.attributes["cache.hit"], s.ended))
+	}
+	return spans
+}
+
+// Check that a miss and a hit are traced, with a child span around the service call
+func TestWithTracer_TracesLookupsAndServiceCalls(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"p1": {price: 5, err: nil},
+		},
+	}
+	tracer := &recordingTracer{}
+	cache := NewTransparentCache(mockService, WithTracer(tracer))
+	getPriceWithNoErr(t, cache, "p1")
+	getPriceWithNoErr(t, cache, "p1")
+	assertStrings(t, []string{
+		"cache.GetPriceFor<>p1:false:true",
+		"cache.service.GetPriceFor<cache.GetPriceFor>p1:<nil>:true",
+		"cache.GetPriceFor<>p1:true:true",
+	}, tracer.get(), "wrong spans")
+}
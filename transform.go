@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Apply the transform of WithTransform to the raw price, telling whether there is one
+func (c *TransparentCache[V]) derive(itemCode string, raw V) (V, bool) {
+	transform := c.transform.Load()
+	if transform == nil {
+		return raw, false
+	}
+	return c.scale((*transform)(itemCode, raw)), true
+}
+
+// Store the price derived from the raw one with the current time, keeping the raw one along, the lock of the shard must be held
+// It returns the derived price, and the price it replaced if there was one
+func (c *TransparentCache[V]) storeDerived(s *shard[V], itemCode string, raw V) (V, V, bool) {
+	return c.storeDerivedAt(s, itemCode, raw, c.clock.Now())
+}
+
+// Store the price derived from the raw one as storeDerived does, stamped as got at cachedAt
+func (c *TransparentCache[V]) storeDerivedAt(s *shard[V], itemCode string, raw V, cachedAt time.Time) (V, V, bool) {
+	price, derived := c.derive(itemCode, raw)
+	old, existed := c.storeEntryAt(s, itemCode, price, cachedAt)
+	if derived {
+		s.rawByItem[itemCode] = raw
+	}
+	return price, old, existed
+}
+
+// SetTransform replaces the transform of WithTransform, nil to cache the prices as they are got
+// The cached prices keep the transform they were derived with until they are got again, unless invalidate is set
+// and every cached price is removed as InvalidateAll does
+func (c *TransparentCache[V]) SetTransform(transform func(itemCode string, raw V) V, invalidate bool) {
+	if transform == nil {
+		c.transform.Store(nil)
+	} else {
+		c.transform.Store(&transform)
+	}
+	if invalidate {
+		c.InvalidateAll()
+	}
+}
+
+// GetRawPriceFor gets the price for the item as GetPriceFor does, but as it was before the transform of WithTransform
+// Prices which were not derived, as the ones cached before there was a transform, are returned as they are cached
+func (c *TransparentCache[V]) GetRawPriceFor(itemCode string) (V, error) {
+	itemCode = c.key(itemCode)
+	price, _, err := c.getPrice(context.Background(), itemCode)
+	if err != nil {
+		return price, err
+	}
+	s := c.shardFor(itemCode)
+	s.RLock()
+	defer s.RUnlock()
+	if raw, ok := s.rawByItem[itemCode]; ok {
+		return raw, nil
+	}
+	return price, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Take 10% off the price of discounted items
+func discount(itemCode string, raw float64) float64 {
+	if strings.HasPrefix(itemCode, "sale-") {
+		return raw * 0.9
+	}
+	return raw
+}
+
+func TestWithTransform_CachesDerivedPrices(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"sale-p1": {price: 10, err: nil},
+			"p2":      {price: 20, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithTransform(discount))
+	assertFloats(t, []float64{9, 20}, getPricesWithNoErr(t, cache, "sale-p1", "p2"), "wrong derived prices")
+	assertFloat(t, 9, getPriceWithNoErr(t, cache, "sale-p1"), "wrong cached derived price")
+	raw, err := cache.GetRawPriceFor("sale-p1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFloat(t, 10, raw, "wrong raw price")
+	assertInt(t, 2, mockService.getNumCalls(), "the derived prices should have been cached")
+	// prices set by hand are derived too
+	cache.Set("sale-p3", 30)
+	assertFloat(t, 27, getPriceWithNoErr(t, cache, "sale-p3"), "wrong derived price for a set price")
+}
+
+func TestSetTransform_ReplacesTheTransform(t *testing.T) {
+	mockService := &mockPriceService{
+		mockResults: map[string]mockResult{
+			"sale-p1": {price: 10, err: nil},
+		},
+	}
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithTransform(discount))
+	assertFloat(t, 9, getPriceWithNoErr(t, cache, "sale-p1"), "wrong derived price")
+	double := func(itemCode string, raw float64) float64 { return raw * 2 }
+	cache.SetTransform(double, false)
+	assertFloat(t, 9, getPriceWithNoErr(t, cache, "sale-p1"), "the cached price should keep its transform")
+	cache.SetTransform(double, true)
+	assertFloat(t, 20, getPriceWithNoErr(t, cache, "sale-p1"), "the price should have been derived again")
+	cache.SetTransform(nil, true)
+	assertFloat(t, 10, getPriceWithNoErr(t, cache, "sale-p1"), "without a transform the raw price is cached")
+	raw, _ := cache.GetRawPriceFor("sale-p1")
+	assertFloat(t, 10, raw, "wrong raw price without a transform")
+	assertInt(t, 3, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// Check that the initial, restored and stored prices are derived too, keeping their raw price
+func TestWithTransform_DerivesSeededRestoredAndStoredPrices(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore[float64]()
+	cache := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store),
+		WithInitialPrices(map[string]float64{"sale-p1": 100}), WithTransform(discount))
+	defer cache.Close()
+	assertFloat(t, 90, getPriceWithNoErr(t, cache, "sale-p1"), "wrong derived initial price")
+	if raw, _ := cache.GetRawPriceFor("sale-p1"); raw != 100 {
+		t.Errorf("expected the raw initial price 100, got %v", raw)
+	}
+
+	data, err := cache.Snapshot()
+	if err != nil {
+		t.Fatal("error taking snapshot", err)
+	}
+	restored := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock), WithTransform(discount))
+	if err := restored.Restore(data); err != nil {
+		t.Fatal("error restoring snapshot", err)
+	}
+	assertFloat(t, 90, getPriceWithNoErr(t, restored, "sale-p1"), "wrong derived restored price")
+
+	// the raw price is saved to the store, so the caches loading it derive it with their own transform
+	cache.Set("sale-p2", 200)
+	waitFor(t, func() bool { _, _, ok, _ := store.Load("sale-p2"); return ok }, "price not saved")
+	if saved, _, _, _ := store.Load("sale-p2"); saved != 200 {
+		t.Errorf("expected the raw price saved, got %v", saved)
+	}
+	half := func(itemCode string, raw float64) float64 { return raw / 2 }
+	second := NewTransparentCache(&mockPriceService{}, WithMaxAge(time.Minute), WithClock(clock), WithStore[float64](store),
+		WithTransform(half))
+	defer second.Close()
+	assertFloat(t, 100, getPriceWithNoErr(t, second, "sale-p2"), "wrong price derived from the store")
+	if raw, _ := second.GetRawPriceFor("sale-p2"); raw != 200 {
+		t.Errorf("expected the raw stored price 200, got %v", raw)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "context"
+
+// Ask a versioned service for the version of the item, keeping the cached price as just got when it didn't change
+// Otherwise it returns the version to store along with the price about to be got, which is empty when the version
+// is unknown, the version being asked before the price so a change in between is noticed on the next revalidation
+// Items which are not cached are asked for it too, so the version is stored along with their first price
+// The call is bounded by ctx and the service timeout, and goes through the rate limiter and the circuit breaker
+// as the calls for prices do
+func (c *TransparentCache[V]) revalidate(ctx context.Context, itemCode string) (V, string, bool) {
+	var zero V
+	getVersion := c.service().getVersion
+	if getVersion == nil {
+		return zero, "", false
+	}
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return zero, "", false
+		}
+	}
+	if c.breaker != nil && !c.breaker.allow() {
+		return zero, "", false
+	}
+	ctx, span := c.startSpan(ctx, "cache.service.GetVersion", itemCode)
+	callCtx, cancel := c.withItemTimeout(ctx, itemCode)
+	c.activeFetches.Add(1)
+	version, err := detached(callCtx, func() (string, error) {
+		defer c.activeFetches.Add(-1)
+		return getVersion(itemCode)
+	})
+	cancel()
+	err = timeoutError(ctx, callCtx, err)
+	c.reportError(ctx, []string{itemCode}, err)
+	endServiceSpan(span, err)
+	if c.breaker != nil {
+		c.breaker.record(err)
+	}
+	if err != nil {
+		return zero, "", false
+	}
+	s := c.shardFor(itemCode)
+	s.Lock()
+	defer s.Unlock()
+	price, ok := s.prices[itemCode]
+	if !ok || s.versionByItem[itemCode] != version {
+		return zero, version, false
+	}
+	s.stamp(itemCode, c.clock.Now())
+	c.touch(s, itemCode)
+	return price, version, true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// versionedPriceService tells a version for every item, which only changes when told to
+type versionedPriceService struct {
+	mockPriceService
+	versionMu    sync.Mutex
+	version      string
+	versionCalls int
+}
+
+func (m *versionedPriceService) GetVersion(itemCode string) (string, error) {
+	m.versionMu.Lock()
+	defer m.versionMu.Unlock()
+	m.versionCalls++
+	return m.version, nil
+}
+
+func (m *versionedPriceService) setVersion(version string) {
+	m.versionMu.Lock()
+	defer m.versionMu.Unlock()
+	m.version = version
+}
+
+// Check that expired prices with an unchanged version are revalidated without getting the price again
+func TestVersionedPriceService_RevalidatesUnchangedPrices(t *testing.T) {
+	mockService := &versionedPriceService{
+		mockPriceService: mockPriceService{
+			mockResults: map[string]mockResult{
+				"p1": {price: 5, err: nil},
+			},
+		},
+		version: "v1",
+	}
+	clock := newFakeClock()
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Minute)
+		assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+		if _, fresh, _ := cache.Peek("p1"); !fresh {
+			t.Error("expected the revalidated price to be fresh")
+		}
+	}
+	assertInt(t, 1, mockService.getNumCalls(), "wrong number of service calls")
+	assertInt(t, 4, mockService.versionCalls, "wrong number of version calls")
+
+	mockService.setVersion("v2")
+	mockService.setResult("p1", mockResult{price: 7})
+	clock.Advance(time.Minute)
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+	clock.Advance(time.Minute)
+	assertFloat(t, 7, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	assertInt(t, 2, mockService.getNumCalls(), "wrong number of service calls")
+}
+
+// hangingVersionService never answers the version, until it is released
+type hangingVersionService struct {
+	mockPriceService
+	release chan struct{}
+}
+
+func (m *hangingVersionService) GetVersion(itemCode string) (string, error) {
+	<-m.release
+	return "v1", nil
+}
+
+// Check that a hanging version call is bounded by the service timeout and by the context of the caller
+func TestVersionedPriceService_BoundsTheVersionCall(t *testing.T) {
+	mockService := &hangingVersionService{
+		mockPriceService: mockPriceService{
+			mockResults: map[string]mockResult{
+				"p1": {price: 5, err: nil},
+			},
+		},
+		release: make(chan struct{}),
+	}
+	defer close(mockService.release)
+	clock := newFakeClock()
+	var failures []error
+	cache := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock),
+		WithServiceTimeout(20*time.Millisecond), WithOnError(func(itemCode string, err error) { failures = append(failures, err) }))
+	cache.Set("p1", 4)
+	clock.Advance(time.Minute)
+	// the version timed out, so the price is got instead
+	assertFloat(t, 5, getPriceWithNoErr(t, cache, "p1"), "wrong price returned")
+	if len(failures) != 1 || !errors.Is(failures[0], ErrServiceTimeout) {
+		t.Errorf("expected the version call to be reported as timed out, got %v", failures)
+	}
+
+	untimed := NewTransparentCache(mockService, WithMaxAge(time.Minute), WithClock(clock))
+	untimed.Set("p1", 4)
+	clock.Advance(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := untimed.GetPriceForContext(ctx, "p1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline of the caller, got %v", err)
+	}
+	waitFor(t, func() bool { return untimed.ActiveFetches() == 1 }, "the version call given up on should still be in flight")
+}